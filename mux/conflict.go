@@ -0,0 +1,198 @@
+package mux
+
+import (
+	"sort"
+	"strings"
+)
+
+// Conflict is a problem of the routes of a Mux: a duplicate or ambiguous
+// pattern, a bad one, or a route that can never be reached.
+type Conflict struct {
+	Problem string // "pattern ambiguous", "pattern existed", ...
+	Method  string // for "pattern existed"
+	Pattern string
+	Other   string // the pattern Pattern conflicts with, or a detail
+}
+
+func (c *Conflict) Error() string {
+	s := "mux: " + c.Problem + ": " + strings.TrimSpace(c.Method+" "+c.Pattern)
+	if c.Other != "" {
+		s += ", " + c.Other
+	}
+	return s
+}
+
+// Conflicts is the error of Build, every problem found.
+type Conflicts []*Conflict
+
+func (cs Conflicts) Error() string {
+	s := make([]string, len(cs))
+	for i, c := range cs {
+		s[i] = c.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// catch runs f, returning the *Conflict it panics with, if any.
+func catch(f func()) (c *Conflict) {
+	defer func() {
+		if r := recover(); r != nil {
+			var ok bool
+			if c, ok = r.(*Conflict); !ok {
+				panic(r)
+			}
+		}
+	}()
+	f()
+	return nil
+}
+
+// fail reports c, met registering a route: after Init it panics, before
+// it is kept for Build, and Init panics with the first one.
+// mux.mu must be held.
+func (mux *Mux) fail(c *Conflict) {
+	if mux.inited {
+		panic(c.Error())
+	}
+	mux.errs = append(mux.errs, c)
+}
+
+// Build is Init reporting every problem of the routes, its mounted and
+// host muxes included, as Conflicts instead of panicking on the first
+// one. Besides the problems Init panics on, it reports the routes of a
+// mounted mux shadowed by ones of mux, and, unless the path policy is
+// Strict, the patterns no canonical path matches. mux is inited only if
+// there is no problem at all, its subs are built first for that.
+func (mux *Mux) Build() error {
+	mux.mu.Lock()
+	if mux.inited {
+		mux.mu.Unlock()
+		return nil
+	}
+	subs := mux.subs()
+	mux.mu.Unlock()
+
+	var serrs Conflicts
+	for _, sub := range subs {
+		if err := sub.Build(); err != nil {
+			serrs = append(serrs, err.(Conflicts)...)
+		}
+	}
+
+	mux.mu.Lock()
+	if mux.inited {
+		mux.mu.Unlock()
+		return nil
+	}
+	errs := append(Conflicts(nil), mux.errs...)
+	t, berrs := mux.buildChecked(mux.m, mux.mws, mux.hosts)
+	errs = append(errs, berrs...)
+	errs = append(errs, mux.unreachable()...)
+	for _, pattern := range sortedPatterns(mux.m) {
+		if rt, ok := mux.m[pattern][""]; ok {
+			if mt, ok := rt.h.(mount); ok {
+				errs = append(errs, mux.shadowed(pattern, mt.sub)...)
+			}
+		}
+	}
+	if len(errs) == 0 && len(serrs) == 0 {
+		mux.store(t)
+		mux.inited = true
+	}
+	mux.mu.Unlock()
+
+	errs = append(errs, serrs...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// subs returns the mounted muxes of mux, by pattern, then the host ones,
+// by host. mux.mu must be held.
+func (mux *Mux) subs() []*Mux {
+	var subs []*Mux
+	for _, pattern := range sortedPatterns(mux.m) {
+		if rt, ok := mux.m[pattern][""]; ok {
+			if mt, ok := rt.h.(mount); ok {
+				subs = append(subs, mt.sub)
+			}
+		}
+	}
+	hosts := make([]string, 0, len(mux.hosts))
+	for host := range mux.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		subs = append(subs, mux.hosts[host].mux)
+	}
+	return subs
+}
+
+// unreachable returns the patterns a path cleaned by the policy never
+// matches. mux.mu must be held.
+func (mux *Mux) unreachable() (errs Conflicts) {
+	if mux.policy == Strict {
+		return nil
+	}
+	for _, pattern := range sortedPatterns(mux.m) {
+		if c := cleanPath("/" + pattern); c != "/"+pattern {
+			errs = append(errs, &Conflict{Problem: "pattern unreachable", Pattern: pattern, Other: c[1:]})
+		}
+	}
+	return errs
+}
+
+// shadowed returns the routes of sub, mounted at pattern, that routes of
+// mux match first. mux.mu must be held.
+func (mux *Mux) shadowed(pattern string, sub *Mux) (errs Conflicts) {
+	prefix := strings.TrimSuffix(pattern, "*"+mountKey+"/")
+
+	shapes := make(map[string]string)
+	for p := range mux.m {
+		if strings.HasPrefix(p, prefix) && p != pattern {
+			shapes[patternShape(p[len(prefix):])] = p
+		}
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	for _, p := range sortedPatterns(sub.m) {
+		if other, ok := shapes[patternShape(p)]; ok {
+			errs = append(errs, &Conflict{Problem: "pattern shadowed", Pattern: prefix + p, Other: other})
+		}
+	}
+	return errs
+}
+
+// patternShape is pattern without capture names: two patterns of the same
+// shape match the same paths.
+func patternShape(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		var w *node
+		if catch(func() { w = parseSegment(pattern, seg) }) != nil || w == nil {
+			continue
+		}
+		switch w.kind {
+		case catchAll:
+			segs[i] = "*"
+		case param:
+			segs[i] = ":"
+		default:
+			segs[i] = ":{" + w.constraint + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func sortedPatterns(m map[string]map[string]*route) []string {
+	patterns := make([]string, 0, len(m))
+	for pattern := range m {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
@@ -0,0 +1,78 @@
+package mux
+
+import (
+	"net/http"
+
+	"github.com/caikaijie/igo/httpcontext"
+	"golang.org/x/net/context"
+)
+
+const dispatchKey key = 1
+
+// contextRoute is the handler of a HandleContext route.
+type contextRoute struct {
+	h httpcontext.ContextHandler
+}
+
+// ServeHTTP serves r with the context of the dispatch r carries, the one
+// with the captures, and passes the context h returns back to it.
+func (cr contextRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d, ok := r.Context().Value(dispatchKey).(*dispatch); ok {
+		d.c = cr.h.ServeHTTPWithContext(d.c, w, r)
+		return
+	}
+	cr.h.ServeHTTPWithContext(r.Context(), w, r)
+}
+
+// contextChain marks the handler of a context route, middleware included,
+// for ServeHTTP and ServeHTTPWithContext to dispatch to.
+type contextChain struct {
+	http.Handler
+}
+
+// dispatch carries the context of a request to a context route through
+// its middleware, and the one the route returns back.
+type dispatch struct {
+	c context.Context
+}
+
+// dispatch serves r with cc, the context route getting c.
+func (cc contextChain) dispatch(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+	// no middleware, no need to go through r.
+	if cr, ok := cc.Handler.(contextRoute); ok {
+		return cr.h.ServeHTTPWithContext(c, w, r)
+	}
+
+	// the middleware gets the captures and metadata in r.
+	rc := r.Context()
+	if pc, ok := c.Value(contextKey).(*paramsContext); ok {
+		rc = &paramsContext{Context: rc, ps: pc.ps, md: pc.md}
+	}
+	d := &dispatch{c: c}
+	cc.ServeHTTP(w, r.WithContext(context.WithValue(rc, dispatchKey, d)))
+	return d.c
+}
+
+// HandleContext registers h for pattern, whatever the request method is.
+// The request is matched once: h gets the captures in its context, both
+// from ServeHTTP and ServeHTTPWithContext, the latter returning the
+// context h returns. Its middleware gets them in the context of the
+// request.
+//
+//	m.HandleContext("users/:id", httpcontext.ContextHandlerFunc(user))
+func (mux *Mux) HandleContext(pattern string, h httpcontext.ContextHandler) {
+	mux.HandleMethodContext("", pattern, h)
+}
+
+// HandleMethodContext is HandleContext for the http method.
+func (mux *Mux) HandleMethodContext(method, pattern string, h httpcontext.ContextHandler) {
+	mux.handle(method, pattern, &route{h: contextRoute{h}})
+}
+
+func (g *Group) HandleContext(pattern string, h httpcontext.ContextHandler) {
+	g.HandleMethodContext("", pattern, h)
+}
+
+func (g *Group) HandleMethodContext(method, pattern string, h httpcontext.ContextHandler) {
+	g.mux.handle(method, g.pattern(pattern), g.route(contextRoute{h}))
+}
@@ -0,0 +1,173 @@
+package mux
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS is a cross-origin resource sharing policy, of the routes registered
+// through Mux.CORS, or of the whole mux with WithCORS.
+type CORS struct {
+	Origins     []string      // allowed, "*" for any
+	Methods     []string      // allowed by a preflight, those of the route if empty
+	Headers     []string      // request headers allowed, the ones asked for if empty
+	Expose      []string      // response headers the browser may read
+	Credentials bool          // cookies and authorization allowed, for the Origins listed but "*"
+	MaxAge      time.Duration // preflight results are cached for, 0 for the browser default
+}
+
+// WithCORS sets the CORS policy of the routes not registered with one.
+// A mounted mux has its own setting, and a host Mux that of its parent.
+func WithCORS(c *CORS) Option {
+	return func(mux *Mux) {
+		mux.cors = c
+	}
+}
+
+// CORS returns a Group whose routes have the policy c: their responses to
+// an allowed origin have the Access-Control-Allow headers, and so do the
+// ones to the preflight OPTIONS requests, see Mux.ServeHTTP.
+//
+//	m.CORS(&mux.CORS{Origins: []string{"https://app.example.com"}}).Put("users/:id", updateUser)
+func (mux *Mux) CORS(c *CORS) *Group {
+	return &Group{mux: mux, cors: c}
+}
+
+// CORS returns a Group for the same prefix, whose routes have the policy c.
+func (g *Group) CORS(c *CORS) *Group {
+	cg := g.clone()
+	cg.cors = c
+	return cg
+}
+
+// origin returns the Access-Control-Allow-Origin of r, if its origin is
+// allowed. An origin allowed by "*" only gets "*", never credentials: it
+// is not echoed, which would let any site read the responses to the
+// requests of a logged-in user.
+func (c *CORS) origin(r *http.Request) (string, bool) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return "", false
+	}
+	if indexOf(c.Origins, origin) != -1 {
+		return origin, true
+	}
+	if indexOf(c.Origins, "*") != -1 {
+		return "*", true
+	}
+	return "", false
+}
+
+// handler wraps h, the handler of a route, to answer a cross-origin
+// request with the headers of c.
+func (c *CORS) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin, ok := c.origin(r); ok {
+			c.allow(w, origin)
+			if len(c.Expose) != 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.Expose, ", "))
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (c *CORS) allow(w http.ResponseWriter, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if origin != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+	if c.Credentials && origin != "*" {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight adds to w the headers answering the preflight r for a route
+// allowing methods.
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, methods []string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+	origin, ok := c.origin(r)
+	if !ok {
+		return
+	}
+	if len(c.Methods) != 0 {
+		methods = c.Methods
+	}
+	if indexOf(methods, r.Header.Get("Access-Control-Request-Method")) == -1 {
+		return
+	}
+
+	c.allow(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(c.Headers) != 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+	} else if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+}
+
+func indexOf(s []string, v string) int {
+	for i, e := range s {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// anyMethods are the ones an any-method route is told to allow.
+var anyMethods = []string{"DELETE", "GET", "PATCH", "POST", "PUT"}
+
+// allowed returns the methods rts are served for, sorted: their own, HEAD
+// along GET, OPTIONS, and anyMethods for an any-method route.
+func allowed(rts map[string]*route) []string {
+	set := map[string]bool{"OPTIONS": true}
+	for method := range rts {
+		if method == "" {
+			for _, m := range anyMethods {
+				set[m] = true
+			}
+			continue
+		}
+		set[method] = true
+	}
+	if set["GET"] {
+		set["HEAD"] = true
+	}
+
+	methods := make([]string, 0, len(set))
+	for method := range set {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// options is the handler of OPTIONS for a pattern with no OPTIONS route.
+type options struct {
+	methods []string         // see allowed
+	cors    map[string]*CORS // method -> policy, "" for any method
+}
+
+func (o options) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(o.methods, ", "))
+	if method := r.Header.Get("Access-Control-Request-Method"); method != "" {
+		c, ok := o.cors[method]
+		if !ok && method == "HEAD" {
+			c, ok = o.cors["GET"]
+		}
+		if !ok {
+			c = o.cors[""]
+		}
+		if c != nil {
+			c.preflight(w, r, o.methods)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
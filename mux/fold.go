@@ -0,0 +1,198 @@
+package mux
+
+import (
+	"regexp"
+	"strings"
+)
+
+// WithCaseInsensitive makes the literal text of the patterns, and the
+// prefix, match the request path whatever its case. The captures keep
+// the case of the request, and their constraints are matched against
+// them as requested. A mounted mux has its own setting, and a host
+// Mux that of its parent.
+func WithCaseInsensitive() Option {
+	return func(mux *Mux) {
+		mux.ignoreCase = true
+	}
+}
+
+// WithNormalization makes the literal text of the patterns, and the
+// prefix, match the request path once both are normalized by norm, such
+// as norm.NFC.String of golang.org/x/text/unicode/norm. norm must keep
+// the slashes. The captures are not normalized.
+func WithNormalization(norm func(string) string) Option {
+	return func(mux *Mux) {
+		mux.norm = norm
+	}
+}
+
+// WithCanonicalRedirect makes a request matching only through
+// WithCaseInsensitive or WithNormalization redirected, 301 (308 but for
+// GET and HEAD), to the path with the literal text as registered. A
+// mounted mux follows the setting of its topmost parent.
+func WithCanonicalRedirect() Option {
+	return func(mux *Mux) {
+		mux.foldRedirect = true
+	}
+}
+
+func (mux *Mux) folds() bool {
+	return mux.ignoreCase || mux.norm != nil
+}
+
+func (mux *Mux) fold(s string) string {
+	if mux.norm != nil {
+		s = mux.norm(s)
+	}
+	if mux.ignoreCase {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// foldPattern folds the literal text of pattern, the tree being built of
+// folded patterns, matched by folded paths.
+func (mux *Mux) foldPattern(pattern string) string {
+	if !mux.folds() {
+		return pattern
+	}
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		if seg == "" {
+			continue
+		}
+		switch w := parseSegment(pattern, seg); {
+		case w == nil:
+			segs[i] = mux.fold(seg)
+		case w.kind == mixed:
+			s := ""
+			for _, part := range w.parts {
+				if part.kind == static {
+					s += mux.fold(part.path)
+				} else {
+					s += part.path
+				}
+			}
+			segs[i] = s
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// foldSegs parses the registered pattern, for node.original to get the
+// captures back from the request path. Literal segments have no kind but
+// path, as in urlTemplate.
+func (mux *Mux) foldSegs(pattern string) []*node {
+	var segs []*node
+	for _, seg := range strings.Split(strings.TrimSuffix(pattern, "/"), "/") {
+		w := parseSegment(pattern, seg)
+		switch {
+		case w == nil && seg != "":
+			w = &node{path: seg}
+		case w == nil:
+			continue
+		case w.kind == mixed:
+			w.re = mux.caseless(w)
+		}
+		segs = append(segs, w)
+	}
+	return segs
+}
+
+// caseless returns the regexp of w, a mixed segment, with its literal
+// text matching whatever its case if mux ignores it, but not its
+// constraints.
+func (mux *Mux) caseless(w *node) *regexp.Regexp {
+	if !mux.ignoreCase {
+		return w.re
+	}
+	return regexp.MustCompile("^" + mixedExpr(w.parts, func(s string) string {
+		return "(?i:" + regexp.QuoteMeta(s) + ")"
+	}) + "$")
+}
+
+// setRaw sets the rawRe of the mixed nodes below n, see lookup.
+func (mux *Mux) setRaw(n *node) {
+	for _, child := range n.statics {
+		mux.setRaw(child)
+	}
+	for _, child := range n.wilds {
+		if child.kind == mixed {
+			child.rawRe = mux.caseless(child)
+		}
+		mux.setRaw(child)
+	}
+}
+
+// trimPrefix returns path after the prefix of mux, and whether path has
+// it, then whether it is as registered.
+func (mux *Mux) trimPrefix(path string) (string, bool, bool) {
+	lp := len(mux.prefix)
+	if len(path) >= lp && path[:lp] == mux.prefix {
+		return path[lp:], true, true
+	}
+	if !mux.folds() {
+		return "", false, false
+	}
+
+	// as many segments as the prefix.
+	i := 0
+	for n := strings.Count(mux.prefix, "/"); n > 0; n-- {
+		j := strings.IndexByte(path[i:], '/')
+		if j == -1 {
+			return "", false, false
+		}
+		i += j + 1
+	}
+	if mux.fold(path[:i]) != mux.fold(mux.prefix) {
+		return "", false, false
+	}
+	return path[i:], true, false
+}
+
+// original appends the captures of path, matched by n once folded, to ps,
+// taken from path itself. folded are the ones the folded path gave, used
+// for a mixed segment its regexp does not match unfolded. It returns path
+// with the literal text as registered.
+func (n *node) original(path string, folded Params, ps *Params) string {
+	buf := make([]byte, 0, len(path))
+	rest := path
+	for _, seg := range n.segs {
+		if seg.kind == catchAll {
+			*ps = append(*ps, Capture{seg.key, rest})
+			buf = append(buf, rest...)
+			rest = ""
+			break
+		}
+
+		i := strings.IndexByte(rest, '/')
+		s := rest[:i]
+		switch seg.kind {
+		case static:
+			buf = append(buf, seg.path...)
+		case mixed:
+			loc := seg.re.FindStringSubmatchIndex(s)
+			k := 0
+			for _, part := range seg.parts {
+				if part.kind == static {
+					continue
+				}
+				v, _ := folded.Get(part.key)
+				if loc != nil {
+					g := seg.groups[k]
+					v = s[loc[2*g]:loc[2*g+1]]
+				}
+				*ps = append(*ps, Capture{part.key, v})
+				k++
+			}
+			buf = append(buf, s...)
+		default:
+			*ps = append(*ps, Capture{seg.key, s})
+			buf = append(buf, s...)
+		}
+		buf = append(buf, '/')
+		rest = rest[i+1:]
+	}
+	buf = append(buf, rest...)
+	return string(buf)
+}
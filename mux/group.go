@@ -0,0 +1,136 @@
+package mux
+
+import (
+	"net/http"
+)
+
+// mountKey names the catch-all a mounted mux is reached through.
+const mountKey = "_mount"
+
+// mount is the handler of a Mount pattern. route hands the request over to
+// sub directly, ServeHTTP is only there to be an http.Handler.
+type mount struct {
+	sub *Mux
+}
+
+func (m mount) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.sub.notFound.ServeHTTP(w, r)
+}
+
+// Mount routes everything below pattern to sub, whatever its prefix is:
+// sub matches the rest of the path and answers its own 404s and 405s.
+// The captures of pattern come first in those of sub, and the middleware
+// of mux for pattern wraps all the handlers of sub. Routes of mux itself
+// below pattern take precedence over sub ones. The URLs of the named
+// routes of sub are below pattern too.
+//
+// sub is to be mounted once, before it serves requests.
+func (mux *Mux) Mount(pattern string, sub *Mux) {
+	mux.mount(pattern, sub, nil)
+}
+
+func (mux *Mux) mount(pattern string, sub *Mux, mws []Middleware) {
+	sub.mu.Lock()
+	if sub.parent != nil {
+		sub.mu.Unlock()
+		panic("mux: already mounted: " + pattern)
+	}
+	sub.parent = mux
+	sub.mountAt = cleanPattern(pattern)
+	sub.mu.Unlock()
+
+	mux.handle("", cleanPattern(pattern)+"*"+mountKey, &route{h: mount{sub}, mws: mws})
+}
+
+// Group registers routes below a common pattern prefix of a Mux.
+type Group struct {
+	mux    *Mux
+	prefix string       // cleaned
+	mws    []Middleware // of the routes registered through the group, see With
+	ms     []Matcher    // of the routes registered through the group, see When
+	cors   *CORS        // of the routes registered through the group, see CORS
+	md     Metadata     // of the routes registered through the group, see Meta
+}
+
+// Group calls fn with a Group for prefix, which may have captures.
+//
+//	m.Group("tenants/:tid", func(g *mux.Group) {
+//		g.Get("users/", users)        // tenants/:tid/users/
+//		g.Get("users/:uid", user)     // tenants/:tid/users/:uid/
+//	})
+func (mux *Mux) Group(prefix string, fn func(g *Group)) {
+	fn(&Group{mux: mux, prefix: cleanPattern(prefix)})
+}
+
+func (g *Group) Group(prefix string, fn func(g *Group)) {
+	sub := g.clone()
+	sub.prefix = g.pattern(prefix)
+	fn(sub)
+}
+
+// clone returns a copy of g, for the methods returning a Group with one
+// setting changed. The slices are shared, they are appended to copies.
+func (g *Group) clone() *Group {
+	c := *g
+	return &c
+}
+
+// route returns the route of h registered through g, with its settings.
+func (g *Group) route(h http.Handler) *route {
+	return &route{h: h, mws: g.mws, ms: g.ms, cors: g.cors, md: g.md}
+}
+
+// Use adds middleware to every route below the group prefix, whether
+// registered through the group or not, and to the 405 and OPTIONS
+// handlers there.
+func (g *Group) Use(mws ...Middleware) {
+	g.mux.use(g.prefix, mws)
+}
+
+// With returns a Group for the same prefix, whose routes are wrapped in mws
+// too.
+func (g *Group) With(mws ...Middleware) *Group {
+	c := g.clone()
+	c.mws = append(append([]Middleware(nil), g.mws...), mws...)
+	return c
+}
+
+func (g *Group) pattern(pattern string) string {
+	return g.prefix + cleanPattern(pattern)
+}
+
+func (g *Group) Handle(pattern string, h http.Handler) {
+	g.HandleMethod("", pattern, h)
+}
+
+func (g *Group) HandleMethod(method, pattern string, h http.Handler) {
+	g.mux.handle(method, g.pattern(pattern), g.route(h))
+}
+
+func (g *Group) HandleNamed(name, pattern string, h http.Handler) {
+	g.mux.handleNamed(name, g.pattern(pattern), g.route(h))
+}
+
+func (g *Group) Mount(pattern string, sub *Mux) {
+	g.mux.mount(g.pattern(pattern), sub, g.mws)
+}
+
+func (g *Group) Get(pattern string, h http.Handler) {
+	g.HandleMethod("GET", pattern, h)
+}
+
+func (g *Group) Post(pattern string, h http.Handler) {
+	g.HandleMethod("POST", pattern, h)
+}
+
+func (g *Group) Put(pattern string, h http.Handler) {
+	g.HandleMethod("PUT", pattern, h)
+}
+
+func (g *Group) Patch(pattern string, h http.Handler) {
+	g.HandleMethod("PATCH", pattern, h)
+}
+
+func (g *Group) Delete(pattern string, h http.Handler) {
+	g.HandleMethod("DELETE", pattern, h)
+}
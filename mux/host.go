@@ -0,0 +1,185 @@
+package mux
+
+import (
+	"sort"
+	"strings"
+)
+
+// hostMux is the Mux of a host pattern with captures.
+type hostMux struct {
+	pattern string
+	labels  []*node // static ones have only a path
+	mux     *Mux
+}
+
+// Host returns the Mux routing the requests for host, creating it on the
+// first call. host is an exact name ("api.example.com") or a pattern
+// whose labels may be captures (":tenant.example.com",
+// ":tenant{alnum}.example.com"); "" and "*" return mux itself.
+//
+// The port and case of the request host are ignored. Exact hosts are tried
+// first, then patterns, those with fewer captures first. The routes of mux
+// itself are the fallback: they serve the hosts matching no pattern, and
+// the paths a host Mux has no route for. Host captures come first in the
+// captures of a request, and the middleware of mux wraps all the handlers
+// of a host Mux.
+func (mux *Mux) Host(host string) *Mux {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || host == "*" {
+		return mux
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if hm, ok := mux.hosts[host]; ok {
+		return hm.mux
+	}
+
+	hm := &hostMux{pattern: host}
+	hm.mux = New(mux.prefix, mux.notFound)
+	hm.mux.policy = mux.policy
+	hm.mux.ignoreCase, hm.mux.norm, hm.mux.foldRedirect = mux.ignoreCase, mux.norm, mux.foldRedirect
+	hm.mux.cors = mux.cors
+	hm.mux.parent = mux
+
+	// a bad or ambiguous host pattern gets a Mux routing nothing.
+	for _, label := range strings.Split(host, ".") {
+		if label != "" && label[0] == ':' {
+			var w *node
+			if c := catch(func() { w = newWild(host, label) }); c != nil {
+				mux.fail(c)
+				return hm.mux
+			}
+			hm.labels = append(hm.labels, w)
+		} else {
+			hm.labels = append(hm.labels, &node{path: label})
+		}
+	}
+	if shape := hm.shape(); shape != host {
+		for _, other := range mux.hosts {
+			if other.shape() == shape {
+				mux.fail(&Conflict{Problem: "host pattern ambiguous", Pattern: host, Other: other.pattern})
+				return hm.mux
+			}
+		}
+	}
+
+	hosts := make(map[string]*hostMux, len(mux.hosts)+1)
+	for k, v := range mux.hosts {
+		hosts[k] = v
+	}
+	hosts[host] = hm
+	if mux.inited {
+		mux.store(mux.buildWith(mux.m, mux.mws, hosts))
+	}
+	mux.hosts = hosts
+
+	return hm.mux
+}
+
+// shape is the pattern without capture names: two patterns of the same
+// shape would match the same hosts.
+func (hm *hostMux) shape() string {
+	labels := make([]string, len(hm.labels))
+	for i, label := range hm.labels {
+		labels[i] = label.path
+		if label.kind != static {
+			labels[i] = ":{" + label.constraint + "}"
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func (hm *hostMux) captures() int {
+	i := 0
+	for _, label := range hm.labels {
+		if label.kind != static {
+			i++
+		}
+	}
+	return i
+}
+
+// byCaptures orders host patterns the way they are tried.
+type byCaptures []*hostMux
+
+func (s byCaptures) Len() int      { return len(s) }
+func (s byCaptures) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCaptures) Less(i, j int) bool {
+	if ci, cj := s[i].captures(), s[j].captures(); ci != cj {
+		return ci < cj
+	}
+	return s[i].pattern < s[j].pattern
+}
+
+// setHosts puts the host muxes of hosts in t.
+func (t *tree) setHosts(hosts map[string]*hostMux) {
+	for host, hm := range hosts {
+		if hm.captures() == 0 {
+			if t.hosts == nil {
+				t.hosts = make(map[string]*Mux)
+			}
+			t.hosts[host] = hm.mux
+		} else {
+			t.hostPatterns = append(t.hostPatterns, hm)
+		}
+	}
+	sort.Sort(byCaptures(t.hostPatterns))
+}
+
+// host returns the Mux for the request host, appending its captures to ps,
+// or nil. The port, case and trailing dot of host do not matter, as for
+// the patterns.
+func (t *tree) host(host string, ps *Params) *Mux {
+	if i := strings.LastIndexByte(host, ':'); i != -1 && strings.IndexByte(host[i:], ']') == -1 {
+		host = host[:i]
+	}
+	host = strings.TrimSuffix(host, ".")
+	for i := 0; i < len(host); i++ {
+		if 'A' <= host[i] && host[i] <= 'Z' {
+			host = strings.ToLower(host)
+			break
+		}
+	}
+
+	if m, ok := t.hosts[host]; ok {
+		return m
+	}
+	for _, hm := range t.hostPatterns {
+		if hm.match(host, ps) {
+			return hm.mux
+		}
+	}
+	return nil
+}
+
+func (hm *hostMux) match(host string, ps *Params) bool {
+	n := len(*ps)
+	for i, label := range hm.labels {
+		var l string
+		if i == len(hm.labels)-1 {
+			l, host = host, ""
+		} else if dot := strings.IndexByte(host, '.'); dot != -1 {
+			l, host = host[:dot], host[dot+1:]
+		} else {
+			*ps = (*ps)[:n]
+			return false
+		}
+
+		if label.kind == static {
+			if l != label.path {
+				*ps = (*ps)[:n]
+				return false
+			}
+			continue
+		}
+		// the last label takes the rest, which must be one label too.
+		if l == "" || strings.IndexByte(l, '.') != -1 || label.re != nil && !label.re.MatchString(l) {
+			*ps = (*ps)[:n]
+			return false
+		}
+		*ps = append(*ps, Capture{label.key, l})
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package mux
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matcher is a condition on a request besides its path and method, for a
+// route to be one variant of its pattern and method, see When.
+type Matcher interface {
+	Match(r *http.Request) bool
+	String() string // stable, told apart variants are by it
+}
+
+// When returns a Group whose routes are variants, served only to the
+// requests all of ms match:
+//
+//	m.Get("users/", usersV1)
+//	m.When(mux.MediaType("application/vnd.acme.v2+json")).Get("users/", usersV2)
+//	m.When(mux.Header("X-API-Version", "2")).Get("users/", usersV2)
+//	m.When(mux.Query("v", "2")).Get("users/", usersV2)
+//
+// After the path match, the variants of the request method are tried in
+// the order they were registered, then its route registered without
+// matchers, if any; then those registered for any method, the same way.
+// A request matching none is answered 406 Not Acceptable.
+func (mux *Mux) When(ms ...Matcher) *Group {
+	return &Group{mux: mux, ms: ms}
+}
+
+// When returns a Group for the same prefix, whose routes must match ms
+// too.
+func (g *Group) When(ms ...Matcher) *Group {
+	c := g.clone()
+	c.ms = append(append([]Matcher(nil), g.ms...), ms...)
+	return c
+}
+
+// matchersString tells the variants of a pattern and method apart.
+func matchersString(ms []Matcher) string {
+	s := make([]string, len(ms))
+	for i, m := range ms {
+		s[i] = m.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// variant is a route of a node, for a method, with matchers.
+type variant struct {
+	ms       []Matcher
+	h        http.Handler
+	defaults Params
+}
+
+func (v *variant) match(r *http.Request) bool {
+	for _, m := range v.ms {
+		if !m.Match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func notAcceptable(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Not acceptable", http.StatusNotAcceptable)
+}
+
+type headerMatcher struct {
+	key, value string
+}
+
+// Header matches the requests whose header key has value, one of its
+// values if it is a list.
+func Header(key, value string) Matcher {
+	return headerMatcher{http.CanonicalHeaderKey(key), value}
+}
+
+func (m headerMatcher) Match(r *http.Request) bool {
+	for _, v := range r.Header[m.key] {
+		for _, v := range strings.Split(v, ",") {
+			if strings.TrimSpace(v) == m.value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m headerMatcher) String() string {
+	return "header " + m.key + "=" + strconv.Quote(m.value)
+}
+
+type headerRegexpMatcher struct {
+	key string
+	re  *regexp.Regexp
+}
+
+// HeaderRegexp matches the requests with a value of header key matching
+// expr, which is not anchored.
+func HeaderRegexp(key, expr string) Matcher {
+	return headerRegexpMatcher{http.CanonicalHeaderKey(key), regexp.MustCompile(expr)}
+}
+
+func (m headerRegexpMatcher) Match(r *http.Request) bool {
+	for _, v := range r.Header[m.key] {
+		if m.re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m headerRegexpMatcher) String() string {
+	return "header " + m.key + "~" + strconv.Quote(m.re.String())
+}
+
+type queryMatcher struct {
+	key    string
+	values []string
+}
+
+// Query matches the requests with the query parameter key, with one of
+// values if any.
+func Query(key string, values ...string) Matcher {
+	return queryMatcher{key, values}
+}
+
+func (m queryMatcher) Match(r *http.Request) bool {
+	vs, ok := r.URL.Query()[m.key]
+	if !ok || len(m.values) == 0 {
+		return ok
+	}
+	for _, v := range vs {
+		for _, value := range m.values {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m queryMatcher) String() string {
+	if len(m.values) == 0 {
+		return "query " + m.key
+	}
+	return "query " + m.key + "=" + strings.Join(m.values, "|")
+}
+
+type mediaTypeMatcher []string
+
+// MediaType matches the requests accepting one of types by name, with a
+// q above 0. Ranges such as "*/*" do not match: they are left to the
+// route without matchers.
+func MediaType(types ...string) Matcher {
+	m := make(mediaTypeMatcher, len(types))
+	for i, t := range types {
+		m[i] = strings.ToLower(t)
+	}
+	return m
+}
+
+func (m mediaTypeMatcher) Match(r *http.Request) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, accept := range strings.Split(accept, ",") {
+			t, params, err := mime.ParseMediaType(accept)
+			if err != nil {
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				if f, err := strconv.ParseFloat(q, 64); err != nil || f <= 0 {
+					continue
+				}
+			}
+			for _, typ := range m {
+				if t == typ {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (m mediaTypeMatcher) String() string {
+	return "accept " + strings.Join(m, "|")
+}
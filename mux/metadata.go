@@ -0,0 +1,66 @@
+package mux
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// Metadata is what a route is registered with besides its handler, such
+// as an auth scope or a rate class, for its middleware to key off:
+//
+//	m.Meta(mux.Metadata{"scope": "users:write", "owner": "accounts"}).Put("users/:id", updateUser)
+//
+//	func auth(h http.Handler) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//			scope, _ := mux.Meta(r, "scope").(string)
+//			...
+//		})
+//	}
+type Metadata map[string]interface{}
+
+// Meta returns a Group whose routes have md, see MetadataFromContext and
+// RouteInfo.
+func (mux *Mux) Meta(md Metadata) *Group {
+	return &Group{mux: mux, md: md}
+}
+
+// Meta returns a Group for the same prefix, whose routes have md too, its
+// keys replacing those of the group.
+func (g *Group) Meta(md Metadata) *Group {
+	merged := make(Metadata, len(g.md)+len(md))
+	for k, v := range g.md {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	c := g.clone()
+	c.md = merged
+	return c
+}
+
+// MetadataFromContext returns the metadata of the route matched, put in c
+// by ServeHTTP, ServeHTTPWithContext or a HandleContext dispatch. It is
+// not to be modified.
+func MetadataFromContext(c context.Context) (Metadata, bool) {
+	pc, ok := c.Value(contextKey).(*paramsContext)
+	if !ok || pc.md == nil {
+		return nil, false
+	}
+	return pc.md, true
+}
+
+// Meta returns the metadata key of the route serving r, nil if there is
+// none.
+func Meta(r *http.Request, key string) interface{} {
+	md, _ := MetadataFromContext(r.Context())
+	return md[key]
+}
+
+// metaRoute marks the handler of a route with metadata, for ServeHTTP
+// and ServeHTTPWithContext to put it in the context.
+type metaRoute struct {
+	http.Handler
+	md Metadata
+}
@@ -0,0 +1,91 @@
+package mux
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware wraps a handler, like http.StripPrefix or a logger does.
+type Middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws, the first one being the outermost.
+func chain(mws []Middleware, h http.Handler) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// chains finds the middleware of a pattern at build time.
+type chains struct {
+	outer    []Middleware
+	mws      map[string][]Middleware
+	prefixes []string // of mws, shortest first
+}
+
+func newChains(outer []Middleware, mws map[string][]Middleware) *chains {
+	cs := &chains{outer: outer, mws: mws}
+	for prefix, _ := range mws {
+		cs.prefixes = append(cs.prefixes, prefix)
+	}
+	sort.Sort(byLen(cs.prefixes))
+	return cs
+}
+
+// of returns the middleware of pattern: the ones of the parent mux, of
+// Use, then of the groups down to pattern, outermost first.
+func (cs *chains) of(pattern string) []Middleware {
+	var mws []Middleware
+	mws = append(mws, cs.outer...)
+	for _, prefix := range cs.prefixes {
+		// cleaned prefixes end with '/': "users/" is not one of "users-x/".
+		if strings.HasPrefix(pattern, prefix) {
+			mws = append(mws, cs.mws[prefix]...)
+		}
+	}
+	return mws
+}
+
+type byLen []string
+
+func (s byLen) Len() int      { return len(s) }
+func (s byLen) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byLen) Less(i, j int) bool {
+	if len(s[i]) != len(s[j]) {
+		return len(s[i]) < len(s[j])
+	}
+	return s[i] < s[j]
+}
+
+// Use adds middleware to every handler of mux, the 404 and 405 ones
+// included, and to those of the muxes mounted on it. OPTIONS answered by
+// the mux, preflights included, go through it too.
+func (mux *Mux) Use(mws ...Middleware) {
+	mux.use("", mws)
+}
+
+// With returns a Group whose routes, and only them, are wrapped in mws,
+// inside any middleware added by Use.
+//
+//	m.With(auth).Post("users/", createUser)
+func (mux *Mux) With(mws ...Middleware) *Group {
+	return &Group{mux: mux, mws: mws}
+}
+
+// use adds mws to the routes below the cleaned prefix.
+func (mux *Mux) use(prefix string, mws []Middleware) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	m := make(map[string][]Middleware, len(mux.mws)+1)
+	for k, v := range mux.mws {
+		m[k] = v
+	}
+	m[prefix] = append(append([]Middleware(nil), m[prefix]...), mws...)
+
+	if mux.inited {
+		mux.store(mux.build(mux.m, m))
+	}
+	mux.mws = m
+}
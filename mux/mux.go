@@ -3,6 +3,8 @@ package mux
 import (
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/caikaijie/igo/httpcontext"
 	"golang.org/x/net/context"
@@ -14,16 +16,63 @@ const contextKey key = 0
 
 var _ httpcontext.ContextHandler = New("", nil)
 
+// Mux routes requests on a tree built at Init from the registered
+// patterns. Handle and Remove can be called at any time from any
+// goroutine: after Init each change builds a new tree, which replaces the
+// old one atomically, so a request in flight keeps seeing the routes as
+// they were when it started.
 type Mux struct {
-	prefix string
-	m      map[string]http.Handler
-	// inited bool
+	prefix   string
+	notFound http.Handler
+	policy   PathPolicy
+
+	ignoreCase   bool                // see WithCaseInsensitive
+	norm         func(string) string // see WithNormalization
+	foldRedirect bool                // see WithCanonicalRedirect
+	cors         *CORS               // see WithCORS
+
+	mu     sync.Mutex                   // guards all but routes and parent
+	m      map[string]map[string]*route // pattern -> method -> route, never mutated once built
+	mws    map[string][]Middleware      // pattern prefix -> middleware, "" for Use, never mutated once built
+	outer  []Middleware                 // the middleware of the parent, for a mounted mux
+	hosts  map[string]*hostMux          // host pattern -> mux, never mutated once built
+	names  map[string]*urlTemplate
+	errs   Conflicts // met registering routes before Init, see fail
+	inited bool
+	routes atomic.Value // *tree
+
+	parent  *Mux   // set by Mount and Host
+	mountAt string // the cleaned pattern sub is mounted at, set by Mount
+}
+
+// route is one registration of a pattern for a method.
+type route struct {
+	h        http.Handler
+	mws      []Middleware // the innermost ones, see Mux.With
+	defaults Params       // of the optional captures the pattern lacks
+	ms       []Matcher    // see Mux.When
+	cors     *CORS        // see Mux.CORS
+	md       Metadata     // see Mux.Meta
+	alt      *route       // the variant registered before, see Mux.When
+}
+
+// tree is what requests are matched on, replaced as a whole on changes.
+type tree struct {
 	root     *node
 	notFound http.Handler
-	// sync.Mutex?
+	mws      []Middleware // of the whole mux
+
+	hosts        map[string]*Mux // exact host -> mux
+	hostPatterns []*hostMux      // in the order they are tried
+
+	// the middleware each mounted or host mux gets from this one.
+	subs map[*Mux][]Middleware
 }
 
-func New(prefix string, notFound http.Handler) *Mux {
+// Option configures a Mux in New.
+type Option func(*Mux)
+
+func New(prefix string, notFound http.Handler, opts ...Option) *Mux {
 	if notFound == nil {
 		notFound = http.HandlerFunc(http.NotFound)
 	}
@@ -39,233 +88,593 @@ func New(prefix string, notFound http.Handler) *Mux {
 	}
 	// "/api/", "/"
 
-	return &Mux{
+	mux := &Mux{
 		prefix:   prefix,
-		m:        make(map[string]http.Handler),
+		m:        make(map[string]map[string]*route),
+		mws:      make(map[string][]Middleware),
+		hosts:    make(map[string]*hostMux),
+		names:    make(map[string]*urlTemplate),
 		notFound: notFound,
 	}
+	for _, opt := range opts {
+		opt(mux)
+	}
+	return mux
 }
 
+// ServeHTTP serves r with the handler matched. A HandleContext one gets
+// the captures in its context, any other one in the context of r, if
+// there are captures: see Param.
+//
+// Unless routes are registered for them, a HEAD request is served by the
+// GET handler, its body discarded, and an OPTIONS one is answered 204 with
+// the Allow header, and the Access-Control-Allow ones of a preflight the
+// CORS policy of the requested method allows.
 func (mux *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h, _ := mux.match(mux.root, r.URL.Path)
-	if h == nil {
-		h = mux.notFound
+	ps := getParams()
+	h := mux.match(r, ps)
+	var md Metadata
+	if mr, ok := h.(metaRoute); ok {
+		h, md = mr.Handler, mr.md
 	}
-
+	if cc, ok := h.(contextChain); ok {
+		c := newContext(r.Context(), *ps, md)
+		putParams(ps)
+		cc.dispatch(c, w, r)
+		return
+	}
+	if len(*ps) != 0 || md != nil {
+		r = r.WithContext(newContext(r.Context(), *ps, md))
+	}
+	putParams(ps)
 	h.ServeHTTP(w, r)
 }
 
+// handler picks the handler of n for r: the variants of its method, then
+// its handler, then the same for the any-method ones, then 406 if there
+// were variants, or else 405. nil n means 404. The defaults of the
+// handler are appended to ps.
+func (t *tree) handler(n *node, r *http.Request, ps *Params) http.Handler {
+	if n == nil {
+		return t.notFound
+	}
+	variants := false
+	for _, method := range [2]string{r.Method, ""} {
+		for i := range n.variants[method] {
+			if v := &n.variants[method][i]; v.match(r) {
+				*ps = append(*ps, v.defaults...)
+				return v.h
+			}
+		}
+		if h, ok := n.hs[method]; ok {
+			*ps = append(*ps, n.defaults[method]...)
+			return h
+		}
+		if _, ok := n.variants[method]; ok {
+			variants = true
+		}
+	}
+	if variants {
+		return n.notAcceptable
+	}
+	return n.notAllowed
+}
+
+// methodNotAllowed is the 405 handler, its value is the Allow header.
+type methodNotAllowed string
+
+func (allow methodNotAllowed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", string(allow))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// head serves HEAD with a GET handler, the body discarded.
+type head struct {
+	http.Handler
+}
+
+func (h head) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Handler.ServeHTTP(headWriter{w}, r)
+}
+
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Flush sends the headers, if w can.
+func (w headWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap is for http.ResponseController to reach w.ResponseWriter.
+func (w headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// headOf is head keeping the marks of h.
+func headOf(h http.Handler) http.Handler {
+	switch h := h.(type) {
+	case metaRoute:
+		return metaRoute{headOf(h.Handler), h.md}
+	case contextChain:
+		return contextChain{head{h.Handler}}
+	}
+	return head{h}
+}
+
 func Err(c context.Context) error {
 	// no error
 	return nil
 }
 
+// FromContext returns the captures as a map, allocated on each call.
+// ParamsFromContext does not allocate.
 func FromContext(c context.Context) (map[string]string, bool) {
-	captures, ok := c.Value(contextKey).(map[string]string)
-	return captures, ok
+	ps, ok := ParamsFromContext(c)
+	if !ok {
+		return nil, false
+	}
+	return ps.Map(), true
+}
+
+// ParamsFromContext returns the captures in c, put there by
+// ServeHTTPWithContext, ServeHTTP or a HandleContext dispatch.
+func ParamsFromContext(c context.Context) (Params, bool) {
+	pc, ok := c.Value(contextKey).(*paramsContext)
+	if !ok {
+		return nil, false
+	}
+	return pc.ps, true
 }
 
-func newContext(parent context.Context, captures map[string]string) context.Context {
-	return context.WithValue(parent, contextKey, captures)
+// Param returns the value of the capture key of r, served by ServeHTTP,
+// "" if there is none.
+//
+//	m.Get("users/:user-id", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//		id := mux.Param(r, "user-id")
+//	}))
+func Param(r *http.Request, key string) string {
+	ps, _ := ParamsFromContext(r.Context())
+	v, _ := ps.Get(key)
+	return v
 }
 
+// paramsContext carries a copy of the captures, and the metadata of the
+// route. Up to len(buf) captures share the allocation of the context
+// itself.
+type paramsContext struct {
+	context.Context
+	ps  Params
+	md  Metadata
+	buf [4]Capture
+}
+
+func (c *paramsContext) Value(key interface{}) interface{} {
+	if key == contextKey {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+// newContext copies ps, as matched, decoding the values.
+func newContext(parent context.Context, ps Params, md Metadata) context.Context {
+	c := &paramsContext{Context: parent, md: md}
+	if len(ps) <= len(c.buf) {
+		c.ps = c.buf[:len(ps)]
+	} else {
+		c.ps = make(Params, len(ps))
+	}
+	for i, p := range ps {
+		c.ps[i] = Capture{p.Key, unescapeValue(p.Value)}
+	}
+	return c
+}
+
+// ServeHTTPWithContext puts the captures of r in the context. A mounted
+// mux matches r from its topmost parent, so the captures of the parents
+// are there too.
+//
+// A HandleContext handler matched is served with that context, which is
+// the one it returns. Other handlers are not served: they are the ones
+// ServeHTTP serves, and may well be a httpcontext.MakeHandler chain
+// calling this very method.
 func (mux *Mux) ServeHTTPWithContext(parent context.Context, w http.ResponseWriter, r *http.Request) context.Context {
-	_, captures := mux.match(mux.root, r.URL.Path)
-	return newContext(parent, captures)
+	top := mux
+	for top.parent != nil {
+		top = top.parent
+	}
+
+	ps := getParams()
+	h := top.match(r, ps)
+	var md Metadata
+	if mr, ok := h.(metaRoute); ok {
+		h, md = mr.Handler, mr.md
+	}
+	c := newContext(parent, *ps, md)
+	putParams(ps)
+
+	if cc, ok := h.(contextChain); ok {
+		return cc.dispatch(c, w, r)
+	}
+	return c
 }
 
+// Handle registers h for pattern, whatever the request method is.
+//
+// Trailing captures can be optional, with a default value reported as
+// captured when the segment is absent:
+//
+//	m.Handle("feeds/:page{uint}?=1", h) // "feeds/" (page=1), "feeds/2/"
 func (mux *Mux) Handle(pattern string, h http.Handler) {
-	if mux.root != nil {
-		panic("mux: already inited.")
-	}
+	mux.HandleMethod("", pattern, h)
+}
 
-	if pattern[0] == '/' {
-		pattern = pattern[1:]
+// HandleMethod registers h for pattern and the http method.
+// A request whose path matches but whose method is not registered
+// gets 405 with an Allow header, unless Handle was used for the pattern too.
+func (mux *Mux) HandleMethod(method, pattern string, h http.Handler) {
+	mux.handle(method, pattern, &route{h: h})
+}
+
+func (mux *Mux) handle(method, pattern string, rt *route) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	mux.add(strings.ToUpper(method), cleanPattern(pattern), rt)
+}
+
+// add registers rt for the cleaned pattern, each of its forms if it has
+// optional captures, and method, reporting whether it did, see fail.
+// mux.mu must be held.
+func (mux *Mux) add(method, pattern string, rt *route) bool {
+	var patterns []string
+	var defaults []Params
+	if c := catch(func() { patterns, defaults = expand(pattern) }); c != nil {
+		mux.fail(c)
+		return false
 	}
-	if pattern != "" && pattern[len(pattern)-1] != '/' {
-		pattern = pattern + "/"
+
+	changes := make(map[string]map[string]*route, len(patterns))
+	for i, pattern := range patterns {
+		old := mux.m[pattern]
+		for alt := old[method]; alt != nil; alt = alt.alt {
+			if matchersString(alt.ms) == matchersString(rt.ms) {
+				mux.fail(&Conflict{Problem: "pattern existed", Method: method, Pattern: pattern, Other: matchersString(rt.ms)})
+				return false
+			}
+		}
+
+		rts := make(map[string]*route, len(old)+1)
+		for m, rt := range old {
+			rts[m] = rt
+		}
+		form := *rt
+		form.defaults = defaults[i]
+		form.alt = old[method]
+		rts[method] = &form
+		changes[pattern] = rts
 	}
-	// "users/", "users/10001/", ""(for root)
+	mux.set(changes)
+	return true
+}
 
-	if _, ok := mux.m[pattern]; ok {
-		panic("mux: pattern existed: " + pattern)
+// Remove unregisters pattern, for all methods.
+func (mux *Mux) Remove(pattern string) {
+	var patterns []string
+	if catch(func() { patterns, _ = expand(cleanPattern(pattern)) }) != nil {
+		return
 	}
 
-	mux.m[pattern] = h
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	changes := make(map[string]map[string]*route, len(patterns))
+	for _, pattern := range patterns {
+		if _, ok := mux.m[pattern]; ok {
+			changes[pattern] = nil
+		}
+	}
+	if len(changes) != 0 {
+		mux.set(changes)
+	}
 }
 
-func (mux *Mux) Init() {
-	if mux.root != nil {
+// RemoveMethod unregisters pattern for the http method, "" being the one
+// registered by Handle.
+func (mux *Mux) RemoveMethod(method, pattern string) {
+	var patterns []string
+	if catch(func() { patterns, _ = expand(cleanPattern(pattern)) }) != nil {
 		return
 	}
+	method = strings.ToUpper(method)
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
 
-	m := make(map[string][]string)
-	for pattern, _ := range mux.m {
-		names := strings.Split(pattern, "/")
-		for _, name := range names {
-			if name == "" {
-				continue
+	changes := make(map[string]map[string]*route, len(patterns))
+	for _, pattern := range patterns {
+		old := mux.m[pattern]
+		if _, ok := old[method]; !ok {
+			continue
+		}
+
+		rts := make(map[string]*route, len(old))
+		for m, rt := range old {
+			if m != method {
+				rts[m] = rt
 			}
-			m[pattern] = append(m[pattern], name+"/")
 		}
+		changes[pattern] = rts
+	}
+	if len(changes) != 0 {
+		mux.set(changes)
 	}
+}
 
-	root := &node{
-		name: mux.prefix,
-		h:    mux.m[""],
+// "/users" -> "users/", "users/10001/", ""(for root)
+func cleanPattern(pattern string) string {
+	if pattern != "" && pattern[0] == '/' {
+		pattern = pattern[1:]
+	}
+	if pattern != "" && pattern[len(pattern)-1] != '/' {
+		pattern = pattern + "/"
 	}
+	return pattern
+}
 
-	makeNode := func(cur *node, pattern, name string) *node {
-		for _, child := range cur.children {
-			if child.name == name {
-				return child
-			} else if child.name[0] == ':' && name[0] == ':' {
-				panic("mux: pattern ambiguous: " + pattern + ", " + child.name)
-			}
+// set replaces the routes of each pattern in changes, an empty one
+// removing the pattern along with its names. Once inited, the tree is
+// rebuilt before anything is changed, so that a conflicting pattern
+// panics leaving the mux as it was. mux.mu must be held.
+func (mux *Mux) set(changes map[string]map[string]*route) {
+	m := mux.m
+	if mux.inited {
+		m = make(map[string]map[string]*route, len(mux.m)+len(changes))
+		for k, v := range mux.m {
+			m[k] = v
 		}
+	}
 
-		// println("new node: " + name)
-		child := &node{name: name}
-		cur.children = append(cur.children, child)
+	for pattern, rts := range changes {
+		if len(rts) == 0 {
+			delete(m, pattern)
+		} else {
+			m[pattern] = rts
+		}
+	}
 
-		return child
+	if mux.inited {
+		mux.store(mux.build(m, mux.mws))
 	}
+	mux.m = m
 
-	for pattern, names := range m {
-		cur := root
-		for _, name := range names {
-			child := makeNode(cur, pattern, name)
-			cur = child
+	for name, t := range mux.names {
+		if rts, ok := changes[t.pattern]; ok && len(rts) == 0 {
+			delete(mux.names, name)
 		}
-		cur.h = mux.m[pattern]
 	}
+}
 
-	// root Handler?
-	// for k, v := range m {
-	// 	if k == "" {
-	// 		root.h = v
-	// 		delete(m, k)
-	// 	}
-	// }
+func (mux *Mux) Get(pattern string, h http.Handler) {
+	mux.HandleMethod("GET", pattern, h)
+}
 
-	mux.root = root
+func (mux *Mux) Post(pattern string, h http.Handler) {
+	mux.HandleMethod("POST", pattern, h)
 }
 
-type node struct {
-	name     string // must end with '/'
-	h        http.Handler
-	children []*node
+func (mux *Mux) Put(pattern string, h http.Handler) {
+	mux.HandleMethod("PUT", pattern, h)
 }
 
-// for debug
-func (mux *Mux) Print() {
-	printNode(mux.root, "")
+func (mux *Mux) Patch(pattern string, h http.Handler) {
+	mux.HandleMethod("PATCH", pattern, h)
+}
+
+func (mux *Mux) Delete(pattern string, h http.Handler) {
+	mux.HandleMethod("DELETE", pattern, h)
 }
 
-func printNode(n *node, indent string) {
-	name := n.name
-	if n.h != nil {
-		name += "(h)"
+// Init builds the tree. It is called by the first request if need be;
+// calling it beforehand makes conflicting patterns panic early. Build
+// reports them all.
+func (mux *Mux) Init() {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if mux.inited {
+		return
 	}
-	println(indent + name)
-	for _, child := range n.children {
-		printNode(child, indent+"\t")
+	if len(mux.errs) != 0 {
+		panic(mux.errs[0].Error())
 	}
+	mux.store(mux.build(mux.m, mux.mws))
+	mux.inited = true
 }
 
-func capture(n *node, path string) (h http.Handler, sub, ck, cv string) {
-	// println("[debug]capturing or matching: " + n.name + " -> " + path)
-	if n.name[0] == ':' {
-		ck = n.name[1 : len(n.name)-1]
-		slashIdx := strings.Index(path, "/")
-		if slashIdx == -1 {
-			return
-		}
-		cv = path[:slashIdx]
-		sub = path[slashIdx+1:]
+func (mux *Mux) load() *tree {
+	t, _ := mux.routes.Load().(*tree)
+	if t == nil {
+		mux.Init()
+		t = mux.routes.Load().(*tree)
+	}
+	return t
+}
 
-		if sub == "" {
-			h = n.h
-			// println("[debug]capture one name[" + ck + ", " + cv + "] successfully, done")
-			return
-		}
-		// println("[debug]capture one name[" + ck + ", " + cv + "] successfully, now sub: " + sub)
-		return
-	} else if l := len(n.name); l <= len(path) && n.name == path[:l] {
-		if l == len(path) {
-			h = n.h
-			// println("[debug]match one name[" + n.name + "] successfully, done." )
-			return
-		}
-		sub = path[l:]
-		if sub == "" {
-			h = n.h
-			// println("[debug]match one name[" + n.name + "] successfully, done." )
-			return
-		}
-		// println("[debug]match one name[" + n.name + "] successfully, now sub: " + sub )
+// store makes t the tree requests are matched on, then passes their
+// middleware on to the mounted muxes. mux.mu must be held.
+func (mux *Mux) store(t *tree) {
+	mux.routes.Store(t)
+	for sub, outer := range t.subs {
+		sub.setOuter(outer)
+	}
+}
+
+func (mux *Mux) setOuter(outer []Middleware) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	mux.outer = outer
+	if mux.inited {
+		mux.store(mux.build(mux.m, mux.mws))
 	}
+}
 
-	// println("[debug]not match one name[" + n.name + "], now will try next.")
+// build makes the tree of m, wrapping the handlers in their middleware,
+// so that the chains are composed once here and not per request.
+func (mux *Mux) build(m map[string]map[string]*route, mws map[string][]Middleware) *tree {
+	return mux.buildWith(m, mws, mux.hosts)
+}
 
-	return
+func (mux *Mux) buildWith(m map[string]map[string]*route, mws map[string][]Middleware, hosts map[string]*hostMux) *tree {
+	t, errs := mux.buildChecked(m, mws, hosts)
+	if len(errs) != 0 {
+		panic(errs[0].Error())
+	}
+	return t
 }
 
-func (mux *Mux) match(root *node, path string) (h http.Handler, captures map[string]string) {
-	if len(path) == 0 || len(path) < len(mux.prefix) {
-		return
+// buildChecked is buildWith returning the conflicts of the patterns, the
+// tree then missing the conflicting ones.
+func (mux *Mux) buildChecked(m map[string]map[string]*route, mws map[string][]Middleware, hosts map[string]*hostMux) (*tree, Conflicts) {
+	// sorted, so that the same pattern set always fails the same way.
+	patterns := sortedPatterns(m)
+
+	chains := newChains(mux.outer, mws)
+	t := &tree{
+		root: &node{path: mux.prefix},
+		mws:  chains.of(""),
 	}
+	t.notFound = chain(t.mws, mux.notFound)
 
-	lp := len(mux.prefix)
-	if mux.prefix == path[:lp] {
-		if len(path) == lp {
-			h = root.h
-			return
-		} else {
-			path = path[lp:]
-		}
-	} else {
-		return
+	t.subs = make(map[*Mux][]Middleware)
+	t.setHosts(hosts)
+	for _, hm := range hosts {
+		t.subs[hm.mux] = t.mws
 	}
 
-	// println("[debug]start match pattern: " + path)
+	var errs Conflicts
+	for _, pattern := range patterns {
+		var n *node
+		if c := catch(func() { n = t.root.insert(mux.foldPattern(pattern)) }); c != nil {
+			errs = append(errs, c)
+			continue
+		}
+		if n.hs != nil {
+			// the same pattern as another once folded.
+			errs = append(errs, &Conflict{Problem: "pattern ambiguous", Pattern: pattern, Other: n.route})
+			continue
+		}
+		n.route = pattern
+		if mux.folds() {
+			n.segs = mux.foldSegs(pattern)
+		}
+		n.setHandlers(m[pattern], chains.of(pattern), mux.cors)
+
+		if rt, ok := m[pattern][""]; ok {
+			if mt, ok := rt.h.(mount); ok {
+				t.subs[mt.sub] = append(chains.of(pattern), rt.mws...)
+				n.mount = mt.sub
+			}
+		}
+	}
+	if mux.folds() {
+		mux.setRaw(t.root)
+	}
+	return t, errs
+}
 
-	ns := root.children
-	p := path
-	captures_ := make(map[string]string)
+// for debug, see Routes for the route table.
+func (mux *Mux) Print() {
+	printNode(mux.load().root, "")
+}
 
-	for {
-		if len(ns) == 0 {
-			return
+// match returns the handler for r, appending the captures to ps, still
+// escaped (see requestPath). Unless the policy is Strict, a path not in
+// its canonical form is redirected or served as the canonical one. The
+// tree is loaded once, so that r sees the routes of a single one.
+func (mux *Mux) match(r *http.Request, ps *Params) http.Handler {
+	t := mux.load()
+	p := requestPath(r)
+	if mux.policy != Strict {
+		if c := cleanPath(p); c != p {
+			return mux.canonical(t, r, p, c, nil, ps)
 		}
+	}
 
-		for _, cur := range ns {
-			h_, sub, ck, cv := capture(cur, p)
+	h, ok := mux.matchPath(t, r, p, ps)
+	if ok || mux.policy == Strict {
+		return h
+	}
+	return mux.canonical(t, r, p, toggleSlash(p), h, ps)
+}
 
-			if ck != "" {
-				captures_[ck] = cv
-			}
+// matchPath returns the handler for path, the one of r or its canonical
+// form, and whether path matched at all.
+func (mux *Mux) matchPath(t *tree, r *http.Request, path string, ps *Params) (http.Handler, bool) {
+	rest, ok, asIs := mux.trimPrefix(path)
+	if !ok {
+		return t.notFound, false
+	}
 
-			if h_ != nil {
-				h = h_
-				captures = captures_
-				return
-			}
+	// println("[debug]start match pattern: " + rest)
 
-			if sub == "" {
-				continue
-			}
-			p = sub
+	h, canon, ok := mux.route(t, r, rest, ps)
+	if ok && mux.foldRedirect && (!asIs || canon != rest) {
+		*ps = (*ps)[:0]
+		return mux.redirect(t, r, mux.prefix+canon), true
+	}
+	return h, ok
+}
 
-			ns = cur.children
-			break
+// route is matchPath for a path relative to the prefix. That is how a
+// mounted mux is matched by its parent, with the rest of the path. canon
+// is path with the literal text as registered, see WithCanonicalRedirect.
+func (mux *Mux) route(t *tree, r *http.Request, path string, ps *Params) (h http.Handler, canon string, ok bool) {
+	if len(t.hosts) != 0 || len(t.hostPatterns) != 0 {
+		n := len(*ps)
+		if hm := t.host(r.Host, ps); hm != nil {
+			if h, canon, ok := hm.route(hm.load(), r, path, ps); ok {
+				return h, canon, true
+			}
+			*ps = (*ps)[:n]
 		}
+	}
 
-		if len(p) == 0 {
-			return
+	var n *node
+	canon = path
+	if !mux.folds() {
+		n = t.root.lookup(path, "", ps)
+	} else {
+		// normalized, for the literal text of mixed segments to match.
+		raw := path
+		if mux.norm != nil {
+			raw = mux.norm(path)
+		}
+		l := len(*ps)
+		n = t.root.lookup(mux.fold(path), raw, ps)
+		if n != nil {
+			m := len(*ps)
+			canon = n.original(path, (*ps)[l:m], ps)
+			*ps = append((*ps)[:l], (*ps)[m:]...)
 		}
 	}
 
-	// TODO: more info?
-	panic("mux: never reached.")
+	if n != nil && n.mount != nil {
+		last := len(*ps) - 1
+		rest := (*ps)[last].Value
+		*ps = (*ps)[:last]
+		h, sub, ok := n.mount.route(n.mount.load(), r, rest, ps)
+		if sub != rest {
+			canon = canon[:len(canon)-len(rest)] + sub
+		}
+		return h, canon, ok
+	}
+	return t.handler(n, r, ps), canon, n != nil
 }
@@ -1,10 +1,17 @@
 package mux_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/caikaijie/igo-middleware/mux"
 	"github.com/caikaijie/igo/httpcontext"
@@ -111,6 +118,40 @@ func BenchmarkCapture(b *testing.B) {
 	}
 
 	m.Init()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var reqs []*http.Request
+	for k, _ := range captureMap {
+		fakeReq, _ := http.NewRequest("whatever", k[1], nil)
+		reqs = append(reqs, fakeReq)
+	}
+
+	i := 0
+	for {
+		for _, req := range reqs {
+			m.ServeHTTP(nil, req)
+			i++
+			if i >= b.N {
+				return
+			}
+		}
+	}
+}
+
+// BenchmarkCapture with one match per request.
+func BenchmarkHandleContext(b *testing.B) {
+	m := mux.New("/api/", nil)
+
+	h := httpcontext.ContextHandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		return c
+	})
+	for k, _ := range captureMap {
+		m.HandleContext(k[0], h)
+	}
+
+	m.Init()
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	var reqs []*http.Request
@@ -138,6 +179,7 @@ func BenchmarkStd(b *testing.B) {
 		m.Handle(k[0], http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	var reqs []*http.Request
@@ -158,6 +200,86 @@ func BenchmarkStd(b *testing.B) {
 	}
 }
 
+func benchmarkMatch(b *testing.B, path string, withContext bool) {
+	m := mux.New("/api/", nil)
+	for k, _ := range captureMap {
+		m.Handle(k[0], http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+	m.Init()
+	req, _ := http.NewRequest("GET", path, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if withContext {
+			m.ServeHTTPWithContext(context.Background(), nil, req)
+		} else {
+			m.ServeHTTP(nil, req)
+		}
+	}
+}
+
+func BenchmarkStatic(b *testing.B) {
+	benchmarkMatch(b, "/api/timelines/", false)
+}
+
+func BenchmarkParams(b *testing.B) {
+	benchmarkMatch(b, "/api/users/user123/feeds/feed123/", false)
+}
+
+func BenchmarkStaticContext(b *testing.B) {
+	benchmarkMatch(b, "/api/timelines/", true)
+}
+
+func BenchmarkParamsContext(b *testing.B) {
+	benchmarkMatch(b, "/api/users/user123/feeds/feed123/", true)
+}
+
+// benchmarkRoutes matches over a table of n routes: static ones,
+// ones ending with a capture, and ones below a shared capture.
+func benchmarkRoutes(b *testing.B, n int) {
+	m := mux.New("/api/", nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var reqs []*http.Request
+	for i := 0; i < n; i++ {
+		var pattern, path string
+		switch i % 3 {
+		case 0:
+			pattern = fmt.Sprintf("svc%d/items/", i)
+			path = fmt.Sprintf("/api/svc%d/items/", i)
+		case 1:
+			pattern = fmt.Sprintf("svc%d/items/:id/", i)
+			path = fmt.Sprintf("/api/svc%d/items/item%d/", i, i)
+		case 2:
+			pattern = fmt.Sprintf("tenants/:tid/svc%d/", i)
+			path = fmt.Sprintf("/api/tenants/t%d/svc%d/", i, i)
+		}
+		m.Handle(pattern, h)
+		req, _ := http.NewRequest("GET", path, nil)
+		reqs = append(reqs, req)
+	}
+	m.Init()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(nil, reqs[i%len(reqs)])
+	}
+}
+
+func BenchmarkRoutes10(b *testing.B) {
+	benchmarkRoutes(b, 10)
+}
+
+func BenchmarkRoutes1k(b *testing.B) {
+	benchmarkRoutes(b, 1000)
+}
+
+func BenchmarkRoutes10k(b *testing.B) {
+	benchmarkRoutes(b, 10000)
+}
+
 /// TestAmbiguous
 /////////////////
 var ambiguousMap = map[string]struct{}{
@@ -184,3 +306,1567 @@ func TestAmbiguous(t *testing.T) {
 // var notFoundMap = map[string]string {
 // 	"/api/users/": "/badpath/",
 // }
+
+/// TestMethod
+//////////////
+func textHandler(s string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(s))
+	})
+}
+
+func TestMethod(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Get("users/:user-id/", textHandler("get user"))
+	m.Put("users/:user-id/", textHandler("put user"))
+	m.HandleMethod("post", "users/", textHandler("post users"))
+	m.Handle("feeds/", textHandler("feeds"))
+	m.Post("feeds/", textHandler("post feeds"))
+	m.Init()
+
+	tests := []struct {
+		method, path string
+		code         int
+		body, allow  string
+	}{
+		{"GET", "/api/users/user123/", 200, "get user", ""},
+		{"PUT", "/api/users/user123/", 200, "put user", ""},
+		{"DELETE", "/api/users/user123/", 405, "", "GET, HEAD, OPTIONS, PUT"},
+		{"POST", "/api/users/", 200, "post users", ""},
+		{"GET", "/api/users/", 405, "", "OPTIONS, POST"},
+		{"GET", "/api/feeds/", 200, "feeds", ""},
+		{"POST", "/api/feeds/", 200, "post feeds", ""},
+		{"GET", "/api/timelines/", 404, "", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("%s %s: code %d, want %d", test.method, test.path, w.Code, test.code)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s %s: body %q, want %q", test.method, test.path, w.Body.String(), test.body)
+		}
+		if allow := w.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: Allow %q, want %q", test.method, test.path, allow, test.allow)
+		}
+	}
+}
+
+/// TestCatchAll
+////////////////
+func TestCatchAll(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Handle("static/*filepath", textHandler("static"))
+	m.Handle("static/favicon.ico", textHandler("favicon"))
+	m.Handle("files/:id/", textHandler("file"))
+	m.Handle("files/*rest", textHandler("files"))
+	m.Init()
+
+	tests := []struct {
+		path, body string
+		captures   map[string]string
+	}{
+		{"/api/static/css/app.css", "static", map[string]string{"filepath": "css/app.css"}},
+		{"/api/static/", "static", map[string]string{"filepath": ""}},
+		{"/api/static/favicon.ico/", "favicon", map[string]string{}},
+		{"/api/files/f1/", "file", map[string]string{"id": "f1"}},
+		{"/api/files/f1/raw/", "files", map[string]string{"rest": "f1/raw/"}},
+		{"/api/files/f1", "files", map[string]string{"rest": "f1"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.path, w.Body.String(), test.body)
+		}
+		c := m.ServeHTTPWithContext(context.Background(), w, req)
+		if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+			t.Errorf("%s: captures %v, want %v", test.path, captures, test.captures)
+		}
+	}
+}
+
+func TestCatchAllConflict(t *testing.T) {
+	for _, patterns := range [][]string{
+		{"static/*filepath", "static/*path"},
+		{"static/*filepath/more"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: no panic", patterns)
+				}
+			}()
+			m := mux.New("/", nil)
+			for _, pattern := range patterns {
+				m.Handle(pattern, textHandler(""))
+			}
+			m.Init()
+		}()
+	}
+}
+
+/// TestConstraint
+//////////////////
+func TestConstraint(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Handle("users/:id{int}/", textHandler("id"))
+	m.Handle("users/:name/", textHandler("name"))
+	m.Handle("users/me/", textHandler("me"))
+	m.Handle("users/:code{[a-z]{2}[0-9]{2}}/feeds/", textHandler("code feeds"))
+	m.Handle("orders/:oid{uuid}/", textHandler("order"))
+	m.Init()
+
+	tests := []struct {
+		path, body string
+		captures   map[string]string
+	}{
+		{"/api/users/123/", "id", map[string]string{"id": "123"}},
+		{"/api/users/-5/", "id", map[string]string{"id": "-5"}},
+		{"/api/users/bob/", "name", map[string]string{"name": "bob"}},
+		{"/api/users/me/", "me", map[string]string{}},
+		{"/api/users/ab12/feeds/", "code feeds", map[string]string{"code": "ab12"}},
+		{"/api/orders/0b5e0f6e-4c1e-4b8a-9d43-3c3f1b2a7e10/", "order", map[string]string{"oid": "0b5e0f6e-4c1e-4b8a-9d43-3c3f1b2a7e10"}},
+		{"/api/orders/42/", "404 page not found\n", nil},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.path, w.Body.String(), test.body)
+		}
+		c := m.ServeHTTPWithContext(context.Background(), w, req)
+		if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+			t.Errorf("%s: captures %v, want %v", test.path, captures, test.captures)
+		}
+	}
+}
+
+func TestConstraintConflict(t *testing.T) {
+	for _, patterns := range [][]string{
+		{"users/:id{int}/", "users/:uid{int}/"},
+		{"users/:id{[0-9+}/"},
+		{"users/:id{[^/]+}/"},
+		{"users/:id{[0-9]+/"},
+		{"users/:id{}/"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: no panic", patterns)
+				}
+			}()
+			m := mux.New("/", nil)
+			for _, pattern := range patterns {
+				m.Handle(pattern, textHandler(""))
+			}
+			m.Init()
+		}()
+	}
+
+	for pattern, want := range map[string]string{
+		"users/:id{[^/]+}/": "mux: bad constraint: users/:id{[^/]+}/, unclosed { in :id{[^",
+		"users/:id{[0-9]+/": "mux: bad constraint: users/:id{[0-9]+/, unclosed { in :id{[0-9]+",
+		"users/:id{}/":      "mux: bad constraint: users/:id{}/, empty constraint in :id{}",
+	} {
+		m := mux.New("/", nil)
+		m.Handle(pattern, textHandler(""))
+		if err := m.Build(); err == nil || err.Error() != want {
+			t.Errorf("%s: Build: %v, want %s", pattern, err, want)
+		}
+	}
+
+	// a host label is a whole capture.
+	m := mux.New("/", nil)
+	m.Host(":tenant{alpha}x.example.com")
+	if err := m.Build(); err == nil || !strings.Contains(err.Error(), "text after }") {
+		t.Errorf("host: Build: %v", err)
+	}
+}
+
+/// TestPrecedence
+//////////////////
+var precedencePatterns = []string{
+	"users/",
+	"users/me/",
+	"users/me/settings/",
+	"users/:id/",
+	"users/:id/feeds/",
+	"users/:id{int}/",
+	"users/:id{int}/followers/",
+	"users/:id/files/*filepath",
+	"users/*rest",
+	"static/*filepath",
+	"static/js/:name/",
+	"u/",
+	"uploads/",
+	"uploads/:name/",
+}
+
+var precedenceTests = []struct {
+	path, pattern string
+	captures      map[string]string
+}{
+	{"/users/", "users/", map[string]string{}},
+	{"/users/me/", "users/me/", map[string]string{}},
+	{"/users/me/settings/", "users/me/settings/", map[string]string{}},
+	{"/users/me/feeds/", "users/:id/feeds/", map[string]string{"id": "me"}},
+	{"/users/bob/", "users/:id/", map[string]string{"id": "bob"}},
+	{"/users/42/", "users/:id{int}/", map[string]string{"id": "42"}},
+	{"/users/42/feeds/", "users/:id/feeds/", map[string]string{"id": "42"}},
+	{"/users/42/followers/", "users/:id{int}/followers/", map[string]string{"id": "42"}},
+	{"/users/bob/followers/", "users/*rest", map[string]string{"rest": "bob/followers/"}},
+	{"/users/me/files/a/b.txt", "users/:id/files/*filepath", map[string]string{"id": "me", "filepath": "a/b.txt"}},
+	{"/users/me/settings/x/", "users/*rest", map[string]string{"rest": "me/settings/x/"}},
+	{"/static/js/app/", "static/js/:name/", map[string]string{"name": "app"}},
+	{"/static/js/app.js", "static/*filepath", map[string]string{"filepath": "js/app.js"}},
+	{"/u/", "u/", map[string]string{}},
+	{"/uploads/", "uploads/", map[string]string{}},
+	{"/uploads/a.png/", "uploads/:name/", map[string]string{"name": "a.png"}},
+	{"/up/", "", nil},
+	{"/feeds/", "", nil},
+}
+
+func TestPrecedence(t *testing.T) {
+	// registration order must not matter.
+	orders := [][]string{precedencePatterns, make([]string, len(precedencePatterns))}
+	for i, pattern := range precedencePatterns {
+		orders[1][len(precedencePatterns)-1-i] = pattern
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 8; i++ {
+		order := make([]string, len(precedencePatterns))
+		for j, k := range rnd.Perm(len(order)) {
+			order[j] = precedencePatterns[k]
+		}
+		orders = append(orders, order)
+	}
+
+	for _, order := range orders {
+		m := mux.New("/", nil)
+		for _, pattern := range order {
+			m.Handle(pattern, textHandler(pattern))
+		}
+		m.Init()
+
+		for _, test := range precedenceTests {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", test.path, nil)
+			m.ServeHTTP(w, req)
+			if test.pattern == "" {
+				if w.Code != http.StatusNotFound {
+					t.Errorf("%v\n%s: code %d, want 404", order, test.path, w.Code)
+				}
+				continue
+			}
+			if w.Body.String() != test.pattern {
+				t.Errorf("%v\n%s: matched %q, want %q", order, test.path, w.Body.String(), test.pattern)
+			}
+			c := m.ServeHTTPWithContext(context.Background(), w, req)
+			if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+				t.Errorf("%v\n%s: captures %v, want %v", order, test.path, captures, test.captures)
+			}
+		}
+	}
+}
+
+/// TestLive
+////////////
+func serveCode(m *mux.Mux, method, path string) int {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	m.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestLive(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Handle("users/", textHandler("users"))
+	// no Init: the first request does it.
+	if code := serveCode(m, "GET", "/api/users/"); code != 200 {
+		t.Errorf("before Init: code %d", code)
+	}
+
+	m.Get("users/:id/", textHandler("user"))
+	m.Delete("users/:id/", textHandler("delete user"))
+	if code := serveCode(m, "GET", "/api/users/u1/"); code != 200 {
+		t.Errorf("after Handle: code %d", code)
+	}
+
+	m.RemoveMethod("DELETE", "users/:id/")
+	if code := serveCode(m, "DELETE", "/api/users/u1/"); code != 405 {
+		t.Errorf("after RemoveMethod: code %d", code)
+	}
+
+	m.Remove("/users/:id")
+	if code := serveCode(m, "GET", "/api/users/u1/"); code != 404 {
+		t.Errorf("after Remove: code %d", code)
+	}
+
+	// a conflict panics and changes nothing.
+	m.Handle("feeds/:id/", textHandler("feed"))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("ambiguous pattern after Init: no panic")
+			}
+		}()
+		m.Handle("feeds/:fid/", textHandler("feed"))
+	}()
+	if code := serveCode(m, "GET", "/api/feeds/f1/"); code != 200 {
+		t.Errorf("after conflict: code %d", code)
+	}
+}
+
+func TestLiveConcurrent(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Handle("users/", textHandler("users"))
+	m.Init()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			pattern := fmt.Sprintf("toggles/t%d/", i%4)
+			m.Handle(pattern, textHandler(pattern))
+			m.Remove(pattern)
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		if code := serveCode(m, "GET", "/api/users/"); code != 200 {
+			t.Fatalf("code %d", code)
+		}
+		serveCode(m, "GET", fmt.Sprintf("/api/toggles/t%d/", i%4))
+	}
+	<-done
+}
+
+/// TestURL
+///////////
+func TestURL(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.HandleNamed("root", "", textHandler("root"))
+	m.HandleNamed("users", "users/", textHandler("users"))
+	m.HandleNamed("feed", "users/:user-id/feeds/:feed-id{int}", textHandler("feed"))
+	m.HandleNamed("file", "files/:owner/*filepath", textHandler("file"))
+	m.Init()
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		url    string
+	}{
+		{"root", nil, "/api/"},
+		{"users", nil, "/api/users/"},
+		{"feed", map[string]string{"user-id": "user123", "feed-id": "42"}, "/api/users/user123/feeds/42/"},
+		{"feed", map[string]string{"user-id": "a b/c", "feed-id": "42"}, "/api/users/a%20b%2Fc/feeds/42/"},
+		{"file", map[string]string{"owner": "bob", "filepath": "docs/a b.txt"}, "/api/files/bob/docs/a%20b.txt"},
+		{"feed", map[string]string{"user-id": "user123"}, ""},
+		{"feed", map[string]string{"user-id": "user123", "feed-id": "x"}, ""},
+		{"nope", nil, ""},
+	}
+	for _, test := range tests {
+		url, err := m.URL(test.name, test.params)
+		if test.url == "" {
+			if err == nil {
+				t.Errorf("%s %v: no error, got %q", test.name, test.params, url)
+			}
+			continue
+		}
+		if err != nil || url != test.url {
+			t.Errorf("%s %v: %q, %v, want %q", test.name, test.params, url, err, test.url)
+		}
+	}
+
+	if url, _ := m.URL("users", nil); serveCode(m, "GET", url) != 200 {
+		t.Errorf("%s: not routed", url)
+	}
+
+	m.Remove("users/")
+	if _, err := m.URL("users", nil); err != mux.ErrRouteNotFound {
+		t.Errorf("removed route: %v", err)
+	}
+
+	// a mounted mux builds its URLs below the mount pattern.
+	billing := mux.New("/billing/", nil)
+	billing.HandleNamed("inv", "invoices/:id", textHandler("invoice"))
+	m.Mount("tenants/:tid/billing", billing)
+	url, err := billing.URL("inv", map[string]string{"tid": "t1", "id": "1"})
+	if url != "/api/tenants/t1/billing/invoices/1/" || err != nil {
+		t.Errorf("mounted: %q, %v", url, err)
+	}
+	if serveCode(m, "GET", url) != 200 {
+		t.Errorf("%s: not routed", url)
+	}
+	if _, err := billing.URL("inv", map[string]string{"id": "1"}); err == nil {
+		t.Errorf("mounted: no error without the captures of the mount pattern")
+	}
+}
+
+/// TestMount
+/////////////
+func TestMount(t *testing.T) {
+	billing := mux.New("/billing/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "billing not found", http.StatusTeapot)
+	}))
+	var captures map[string]string
+	billing.Get("invoices/:iid", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := billing.ServeHTTPWithContext(context.Background(), w, r)
+		captures, _ = mux.FromContext(c)
+	}))
+
+	m := mux.New("/api/", nil)
+	m.Group("tenants/:tid", func(g *mux.Group) {
+		g.Get("users/", textHandler("users"))
+		g.Group("billing", func(g *mux.Group) {
+			g.Get("health/", textHandler("health"))
+		})
+		g.Mount("billing", billing)
+	})
+	m.Init()
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/api/tenants/t1/users/", 200, "users"},
+		{"GET", "/api/tenants/t1/billing/invoices/i1/", 200, ""},
+		{"POST", "/api/tenants/t1/billing/invoices/i1/", 405, ""},
+		{"GET", "/api/tenants/t1/billing/nope/", 418, "billing not found\n"},
+		{"GET", "/api/tenants/t1/billing/health/", 200, "health"},
+		{"GET", "/api/tenants/t1/nope/", 404, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("%s %s: code %d, want %d", test.method, test.path, w.Code, test.code)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s %s: body %q, want %q", test.method, test.path, w.Body.String(), test.body)
+		}
+	}
+
+	want := map[string]string{"tid": "t1", "iid": "i1"}
+	if !sameMap(captures, want) {
+		t.Errorf("mounted captures %v, want %v", captures, want)
+	}
+
+	// the 404 of the mounted mux whatever the policy.
+	for _, policy := range []mux.PathPolicy{mux.Strict, mux.Redirect, mux.Tolerant} {
+		sub := mux.New("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+		sub.Get("invoices/", textHandler("invoices"))
+		p := mux.New("/api/", nil, mux.WithPathPolicy(policy))
+		p.Mount("billing/", sub)
+		for _, path := range []string{"/api/billing/nope/", "/api/billing/nope", "/api/billing//nope/"} {
+			if code := serveCode(p, "GET", path); code != http.StatusTeapot {
+				t.Errorf("policy %d: %s: code %d, want 418", policy, path, code)
+			}
+		}
+	}
+}
+
+/// TestMiddleware
+//////////////////
+func traceMiddleware(name string) mux.Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	sub := mux.New("/", nil)
+	sub.Use(traceMiddleware("sub"))
+	sub.Get("reports/", textHandler("reports"))
+
+	m := mux.New("/api/", nil)
+	m.Use(traceMiddleware("log"))
+	m.Get("users/", textHandler("users"))
+	m.With(traceMiddleware("route")).Post("users/", textHandler("post users"))
+	m.Group("admin", func(g *mux.Group) {
+		g.Use(traceMiddleware("auth"))
+		g.Get("stats/", textHandler("stats"))
+		g.Mount("billing", sub)
+	})
+	m.Init()
+
+	tests := []struct {
+		method, path string
+		code         int
+		trace        string
+	}{
+		{"GET", "/api/users/", 200, "log"},
+		{"POST", "/api/users/", 200, "log,route"},
+		{"PUT", "/api/users/", 405, "log"},
+		{"GET", "/api/admin/stats/", 200, "log,auth"},
+		{"PUT", "/api/admin/stats/", 405, "log,auth"},
+		{"GET", "/api/admin/billing/reports/", 200, "log,auth,sub"},
+		{"GET", "/api/admin/billing/nope/", 404, "log,auth,sub"},
+		{"GET", "/api/nope/", 404, "log"},
+		{"GET", "/nope/", 404, "log"},
+	}
+	check := func(when string) {
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(test.method, test.path, nil)
+			m.ServeHTTP(w, req)
+			if w.Code != test.code {
+				t.Errorf("%s: %s %s: code %d, want %d", when, test.method, test.path, w.Code, test.code)
+			}
+			if trace := strings.Join(w.Header()["X-Trace"], ","); trace != test.trace {
+				t.Errorf("%s: %s %s: trace %q, want %q", when, test.method, test.path, trace, test.trace)
+			}
+		}
+	}
+	check("Init")
+
+	// after Init, Use rebuilds the chains, mounted ones included.
+	m.Use(traceMiddleware("metrics"))
+	for i := range tests {
+		tests[i].trace = strings.Replace(tests[i].trace, "log", "log,metrics", 1)
+	}
+	check("Use after Init")
+}
+
+/// TestPathPolicy
+//////////////////
+func TestPathPolicy(t *testing.T) {
+	tests := []struct {
+		policy       mux.PathPolicy
+		method, path string
+		code         int
+		location     string
+	}{
+		{mux.Strict, "GET", "/api/users/u1/", 200, ""},
+		{mux.Strict, "GET", "/api/users/u1", 404, ""},
+		{mux.Strict, "GET", "/api//users/u1/", 404, ""},
+
+		{mux.Redirect, "GET", "/api/users/u1/", 200, ""},
+		{mux.Redirect, "GET", "/api/users/u1?x=1&y=2", 301, "/api/users/u1/?x=1&y=2"},
+		{mux.Redirect, "POST", "/api/users/u1", 308, "/api/users/u1/"},
+		{mux.Redirect, "GET", "/api//users/./u1/../u2/", 301, "/api/users/u2/"},
+		{mux.Redirect, "GET", "/api/users/u1/profile", 404, ""},
+		{mux.Redirect, "GET", "/api/static/css/app.css", 200, ""},
+		{mux.Redirect, "GET", "/api/static/../users/u1", 301, "/api/users/u1/"},
+		{mux.Redirect, "GET", "/api", 301, "/api/"},
+
+		{mux.Tolerant, "GET", "/api/users/u1", 200, ""},
+		{mux.Tolerant, "GET", "/api//users/./u1/../u2", 200, ""},
+		{mux.Tolerant, "GET", "/api/users/u1/profile", 404, ""},
+	}
+	for _, test := range tests {
+		m := mux.New("/api/", nil, mux.WithPathPolicy(test.policy))
+		m.Handle("", textHandler("root"))
+		m.Handle("users/:id", textHandler("user"))
+		m.Handle("static/*filepath", textHandler("static"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("%d %s %s: code %d, want %d", test.policy, test.method, test.path, w.Code, test.code)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%d %s %s: Location %q, want %q", test.policy, test.method, test.path, location, test.location)
+		}
+	}
+
+	m := mux.New("/api/", nil, mux.WithPathPolicy(mux.Tolerant))
+	m.Handle("users/:id", textHandler("user"))
+	req, _ := http.NewRequest("GET", "/api//users/./u1/../u2", nil)
+	c := m.ServeHTTPWithContext(context.Background(), nil, req)
+	if captures, _ := mux.FromContext(c); captures["id"] != "u2" {
+		t.Errorf("tolerant captures %v", captures)
+	}
+}
+
+/// TestEscapedPath
+///////////////////
+func TestEscapedPath(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Handle("users/:id/", textHandler("user"))
+	m.Handle("users/:id/feeds/", textHandler("feeds"))
+	m.Handle("users/:id{int}/", textHandler("user int"))
+	m.Handle("café/", textHandler("café"))
+	m.Handle("files/*path", textHandler("files"))
+	m.Init()
+
+	tests := []struct {
+		path, body string
+		captures   map[string]string
+	}{
+		{"/api/users/a%2Fb/", "user", map[string]string{"id": "a/b"}},
+		{"/api/users/a%2fb/feeds/", "feeds", map[string]string{"id": "a/b"}},
+		{"/api/users/caf%C3%A9/", "user", map[string]string{"id": "café"}},
+		{"/api/users/✓/", "user", map[string]string{"id": "✓"}},
+		{"/api/users/a+b/", "user", map[string]string{"id": "a+b"}},
+		{"/api/users/a%20b/", "user", map[string]string{"id": "a b"}},
+		{"/api/users/100%25/", "user", map[string]string{"id": "100%"}},
+		{"/api/users/%32%33/", "user int", map[string]string{"id": "23"}},
+		{"/api/caf%C3%A9/", "café", map[string]string{}},
+		{"/api/café/", "café", map[string]string{}},
+		{"/api/files/x%2Fy/z%20.txt", "files", map[string]string{"path": "x/y/z .txt"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.path, w.Body.String(), test.body)
+		}
+		c := m.ServeHTTPWithContext(context.Background(), w, req)
+		if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+			t.Errorf("%s: captures %v, want %v", test.path, captures, test.captures)
+		}
+	}
+
+	// URL builds what the route matches back.
+	m.HandleNamed("likes", "users/:id/likes/", textHandler("likes"))
+	if url, _ := m.URL("likes", map[string]string{"id": "a/b c"}); url != "/api/users/a%2Fb%20c/likes/" {
+		t.Errorf("URL %q", url)
+	} else if code := serveCode(m, "GET", url); code != 200 {
+		t.Errorf("URL %q: code %d", url, code)
+	}
+
+	r := mux.New("/api/", nil, mux.WithPathPolicy(mux.Redirect))
+	r.Handle("users/:id/", textHandler("user"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/users/a%2Fb%20c", nil)
+	r.ServeHTTP(w, req)
+	if location := w.Header().Get("Location"); w.Code != 301 || location != "/api/users/a%2Fb%20c/" {
+		t.Errorf("redirect %d %q", w.Code, location)
+	}
+}
+
+/// TestHost
+////////////
+func TestHost(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Use(traceMiddleware("log"))
+	m.Get("health/", textHandler("health"))
+	m.Get("users/", textHandler("any users"))
+	m.Host("api.example.com").Get("users/", textHandler("api users"))
+	m.Host(":tenant.example.com").Get("users/:id/", textHandler("tenant user"))
+	m.Host(":tenant.:region.example.com").Get("users/:id/", textHandler("regional user"))
+	m.Host("www.:tld").Get("users/", textHandler("www users"))
+	m.Init()
+
+	// a host added after Init is live.
+	m.Host("admin.example.com").Get("users/", textHandler("admin users"))
+
+	tests := []struct {
+		host, path, body string
+		captures         map[string]string
+	}{
+		{"api.example.com", "/api/users/", "api users", map[string]string{}},
+		{"API.Example.com:8080", "/api/users/", "api users", map[string]string{}},
+		{"api.example.com.", "/api/users/", "api users", map[string]string{}},
+		{"acme.example.com.:8080", "/api/users/u1/", "tenant user", map[string]string{"tenant": "acme", "id": "u1"}},
+		{"admin.example.com", "/api/users/", "admin users", map[string]string{}},
+		{"acme.example.com", "/api/users/u1/", "tenant user", map[string]string{"tenant": "acme", "id": "u1"}},
+		{"acme.eu.example.com", "/api/users/u1/", "regional user", map[string]string{"tenant": "acme", "region": "eu", "id": "u1"}},
+		{"acme.example.com", "/api/health/", "health", map[string]string{}},
+		{"acme.example.com", "/api/users/", "any users", map[string]string{}},
+		{"other.org", "/api/users/", "any users", map[string]string{}},
+		{"www.io", "/api/users/", "www users", map[string]string{"tld": "io"}},
+		{"www.co.evil.example", "/api/users/", "any users", map[string]string{}},
+		{"other.org", "/api/users/u1/", "404 page not found\n", map[string]string{}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://"+test.host+test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body {
+			t.Errorf("%s%s: body %q, want %q", test.host, test.path, w.Body.String(), test.body)
+		}
+		if trace := w.Header().Get("X-Trace"); trace != "log" {
+			t.Errorf("%s%s: trace %q", test.host, test.path, trace)
+		}
+		c := m.ServeHTTPWithContext(context.Background(), w, req)
+		if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+			t.Errorf("%s%s: captures %v, want %v", test.host, test.path, captures, test.captures)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("ambiguous host pattern: no panic")
+		}
+	}()
+	m.Host(":org.example.com")
+}
+
+/// TestMixed
+/////////////
+func TestMixed(t *testing.T) {
+	m := mux.New("/", nil)
+	m.Handle("files/:name.:ext/", textHandler("file"))
+	m.Handle("files/:name/", textHandler("name"))
+	m.Handle("files/index.html/", textHandler("index"))
+	m.HandleNamed("report", "reports/:year{int}-:month{int}.csv/", textHandler("report"))
+	m.Handle("reports/report-:year.csv/", textHandler("named report"))
+	m.Handle("users/:id:batchGet/", textHandler("batch"))
+	m.Init()
+
+	tests := []struct {
+		path, body string
+		captures   map[string]string
+	}{
+		{"/files/a.txt/", "file", map[string]string{"name": "a", "ext": "txt"}},
+		{"/files/a.tar.gz/", "file", map[string]string{"name": "a.tar", "ext": "gz"}},
+		{"/files/readme/", "name", map[string]string{"name": "readme"}},
+		{"/files/index.html/", "index", map[string]string{}},
+		{"/files/.txt/", "name", map[string]string{"name": ".txt"}},
+		{"/reports/2024-05.csv/", "report", map[string]string{"year": "2024", "month": "05"}},
+		{"/reports/2024-may.csv/", "404 page not found\n", nil},
+		{"/reports/report-2024.csv/", "named report", map[string]string{"year": "2024"}},
+		{"/users/42:batchGet/", "batch", map[string]string{"id": "42"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.path, w.Body.String(), test.body)
+		}
+		c := m.ServeHTTPWithContext(context.Background(), w, req)
+		if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+			t.Errorf("%s: captures %v, want %v", test.path, captures, test.captures)
+		}
+	}
+
+	u, err := m.URL("report", map[string]string{"year": "2024", "month": "05"})
+	if err != nil || u != "/reports/2024-05.csv/" {
+		t.Errorf("URL: %q, %v", u, err)
+	}
+	if _, err := m.URL("report", map[string]string{"year": "2024", "month": "may"}); err == nil {
+		t.Errorf("URL: no error for a bad month")
+	}
+}
+
+func TestMixedConflict(t *testing.T) {
+	for _, patterns := range [][]string{
+		{"files/:name.:ext/", "files/:base.:suffix/"},
+		{"reports/:y{int}-:m{int}/", "reports/:year{int}-:month{int}/"},
+		{"files/:name.:ext{[a-z+}/"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: no panic", patterns)
+				}
+			}()
+			m := mux.New("/", nil)
+			for _, pattern := range patterns {
+				m.Handle(pattern, textHandler(""))
+			}
+			m.Init()
+		}()
+	}
+
+	// different separators are not ambiguous.
+	m := mux.New("/", nil)
+	m.Handle("files/:name.:ext/", textHandler(""))
+	m.Handle("files/:name-:ext/", textHandler(""))
+	m.Init()
+}
+
+/// TestOptional
+////////////////
+func TestOptional(t *testing.T) {
+	m := mux.New("/", nil)
+	m.Handle("feeds/:page{uint}?=1", textHandler("feeds"))
+	m.Get("archive/:year?/:month?=01", textHandler("archive"))
+	m.HandleNamed("tags", "tags/:tag?", textHandler("tags"))
+	m.Init()
+
+	tests := []struct {
+		path, body string
+		captures   map[string]string
+	}{
+		{"/feeds/", "feeds", map[string]string{"page": "1"}},
+		{"/feeds/3/", "feeds", map[string]string{"page": "3"}},
+		{"/feeds/x/", "404 page not found\n", nil},
+		{"/archive/", "archive", map[string]string{"month": "01"}},
+		{"/archive/2024/", "archive", map[string]string{"year": "2024", "month": "01"}},
+		{"/archive/2024/05/", "archive", map[string]string{"year": "2024", "month": "05"}},
+		{"/tags/", "tags", map[string]string{}},
+		{"/tags/go/", "tags", map[string]string{"tag": "go"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.path, w.Body.String(), test.body)
+		}
+		c := m.ServeHTTPWithContext(context.Background(), w, req)
+		if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+			t.Errorf("%s: captures %v, want %v", test.path, captures, test.captures)
+		}
+	}
+
+	if u, err := m.URL("tags", nil); err != nil || u != "/tags/" {
+		t.Errorf("URL: %q, %v", u, err)
+	}
+	if u, err := m.URL("tags", map[string]string{"tag": "go"}); err != nil || u != "/tags/go/" {
+		t.Errorf("URL: %q, %v", u, err)
+	}
+
+	m.Remove("feeds/:page{uint}?=1")
+	if code := serveCode(m, "GET", "/feeds/"); code != 404 {
+		t.Errorf("removed /feeds/: %d", code)
+	}
+	if code := serveCode(m, "GET", "/feeds/2/"); code != 404 {
+		t.Errorf("removed /feeds/2/: %d", code)
+	}
+	m.Remove("tags/:tag?")
+	if _, err := m.URL("tags", nil); err != mux.ErrRouteNotFound {
+		t.Errorf("URL of a removed route: %v", err)
+	}
+}
+
+func TestOptionalConflict(t *testing.T) {
+	for _, patterns := range [][]string{
+		{"feeds/", "feeds/:page?"},
+		{"archive/:year?/:month/"},
+		{"feeds/:page{uint}?=first"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: no panic", patterns)
+				}
+			}()
+			m := mux.New("/", nil)
+			for _, pattern := range patterns {
+				m.Handle(pattern, textHandler(""))
+			}
+			m.Init()
+		}()
+	}
+}
+
+/// TestRoutes
+//////////////
+func usersHandler(w http.ResponseWriter, r *http.Request) {}
+
+func TestRoutes(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Use(traceMiddleware("use"))
+	m.Get("users/", http.HandlerFunc(usersHandler))
+	m.With(traceMiddleware("auth")).Post("users/", textHandler(""))
+	m.HandleNamed("user", "users/:id", textHandler(""))
+	m.Handle("feeds/:page?", textHandler(""))
+
+	sub := mux.New("/", nil)
+	sub.Get("files/", textHandler(""))
+	m.Mount("static/", sub)
+	m.Host(":tenant.example.com").Get("home/", textHandler(""))
+
+	var got []string
+	for _, r := range m.Routes() {
+		got = append(got, fmt.Sprintf("%s|%s|%s|%s|%d", r.Host, r.Pattern, r.Method, r.Name, len(r.Middleware)))
+		if r.Method == "GET" && r.Pattern == "/api/users/" && !strings.HasSuffix(r.Handler, "mux_test.usersHandler") {
+			t.Errorf("handler name %q", r.Handler)
+		}
+	}
+	want := []string{
+		"|/api/feeds/|||1",
+		"|/api/feeds/:page/|||1",
+		"|/api/static/files/|GET||1",
+		"|/api/users/|GET||1",
+		"|/api/users/|POST||2",
+		"|/api/users/:id/||user|1",
+		":tenant.example.com|/api/home/|GET||1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("routes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var buf bytes.Buffer
+	if err := mux.WriteJSON(&buf, m.Routes()); err != nil {
+		t.Fatal(err)
+	}
+	var routes []mux.RouteInfo
+	if err := json.Unmarshal(buf.Bytes(), &routes); err != nil || len(routes) != len(want) {
+		t.Errorf("JSON: %d routes, %v", len(routes), err)
+	}
+
+	buf.Reset()
+	if err := mux.WriteDOT(&buf, m.Routes()); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"digraph mux {", `"/api/" -> "/api/users/";`, `"/api/users/" -> "/api/users/:id/";`, `":tenant.example.com/"`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("DOT: no %s in\n%s", s, buf.String())
+		}
+	}
+}
+
+/// TestBuild
+/////////////
+func TestBuild(t *testing.T) {
+	m := mux.New("/", nil, mux.WithPathPolicy(mux.Redirect))
+	m.Get("users/", textHandler("users"))
+	m.Get("users/", textHandler("users again"))
+	m.Handle("users/:id/", textHandler(""))
+	m.Handle("users/:uid/", textHandler(""))
+	m.Handle("feeds/:fid{[0-9+}/", textHandler(""))
+	m.HandleNamed("user", "accounts/:id/", textHandler(""))
+	m.HandleNamed("user", "members/:id/", textHandler(""))
+	m.Handle("docs/./index/", textHandler(""))
+	m.Handle("static/files/", textHandler(""))
+	sub := mux.New("/", nil)
+	sub.Get("files/", textHandler(""))
+	sub.Get("images/", textHandler(""))
+	sub.Handle("a/:x/", textHandler(""))
+	sub.Handle("a/:y/", textHandler(""))
+	m.Mount("static/", sub)
+
+	err := m.Build()
+	cs, ok := err.(mux.Conflicts)
+	if !ok {
+		t.Fatalf("Build: %v", err)
+	}
+	want := []string{
+		"mux: pattern existed: GET users/",
+		"mux: name existed: members/:id/, user",
+		"mux: bad constraint: feeds/:fid{[0-9+}/",
+		"mux: pattern ambiguous: users/:uid/, users/:id/",
+		"mux: pattern unreachable: docs/./index/, docs/index/",
+		"mux: pattern shadowed: static/files/, static/files/",
+		"mux: pattern ambiguous: a/:y/, a/:x/",
+	}
+	if len(cs) != len(want) {
+		t.Fatalf("Build: %d conflicts, want %d:\n%v", len(cs), len(want), err)
+	}
+	for i, c := range cs {
+		if !strings.HasPrefix(c.Error(), want[i]) {
+			t.Errorf("conflict %d: %q, want %q", i, c.Error(), want[i])
+		}
+	}
+	if c := cs[3]; c.Pattern != "users/:uid/" || c.Other != "users/:id/" {
+		t.Errorf("ambiguous pair: %q, %q", c.Pattern, c.Other)
+	}
+
+	// Init panics with the first one.
+	func() {
+		defer func() {
+			if r, _ := recover().(string); r != want[0] {
+				t.Errorf("Init: panic %q, want %q", r, want[0])
+			}
+		}()
+		m.Init()
+	}()
+
+	good := mux.New("/", nil)
+	good.Get("users/", textHandler("users"))
+	if err := good.Build(); err != nil {
+		t.Errorf("Build: %v", err)
+	}
+	if code := serveCode(good, "GET", "/users/"); code != 200 {
+		t.Errorf("after Build: code %d", code)
+	}
+
+	// a conflict of a mounted mux only leaves the parent not inited too.
+	parent := mux.New("/", nil)
+	parent.Get("users/", textHandler("users"))
+	bad := mux.New("/", nil)
+	bad.Handle("a/:x/", textHandler(""))
+	bad.Handle("a/:y/", textHandler(""))
+	parent.Mount("bad/", bad)
+	if err := parent.Build(); err == nil {
+		t.Error("Build: no conflict of the mounted mux")
+	}
+	parent.Get("users/", textHandler("users again"))
+	if err := parent.Build(); err == nil || !strings.HasPrefix(err.Error(), "mux: pattern existed: GET users/") {
+		t.Errorf("Build again: %v", err)
+	}
+}
+
+/// TestHandleContext
+/////////////////////
+type ctxKey string
+
+func TestHandleContext(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Use(traceMiddleware("use"))
+
+	served := 0
+	m.HandleContext("users/:id", httpcontext.ContextHandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		served++
+		ps, _ := mux.ParamsFromContext(c)
+		id, _ := ps.Get("id")
+		w.Write([]byte("user " + id))
+		return context.WithValue(c, ctxKey("served"), true)
+	}))
+	m.Handle("feeds/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+	m.Init()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/users/u1/", nil)
+	m.ServeHTTP(w, req)
+	if w.Body.String() != "user u1" || w.Header().Get("X-Trace") != "use" || served != 1 {
+		t.Errorf("ServeHTTP: body %q, trace %q, served %d", w.Body.String(), w.Header().Get("X-Trace"), served)
+	}
+
+	w = httptest.NewRecorder()
+	c := m.ServeHTTPWithContext(context.WithValue(context.Background(), ctxKey("parent"), 1), w, req)
+	if w.Body.String() != "user u1" || served != 2 {
+		t.Errorf("ServeHTTPWithContext: body %q, served %d", w.Body.String(), served)
+	}
+	if c.Value(ctxKey("served")) != true || c.Value(ctxKey("parent")) != 1 {
+		t.Errorf("ServeHTTPWithContext: context of the handler not returned")
+	}
+	if captures, _ := mux.FromContext(c); !sameMap(captures, map[string]string{"id": "u1"}) {
+		t.Errorf("ServeHTTPWithContext: captures %v", captures)
+	}
+
+	// a plain handler is left to ServeHTTP.
+	req, _ = http.NewRequest("GET", "/api/feeds/", nil)
+	m.ServeHTTPWithContext(context.Background(), httptest.NewRecorder(), req)
+	if served != 2 {
+		t.Errorf("plain handler served by ServeHTTPWithContext")
+	}
+}
+
+/// TestParam
+/////////////
+func TestParam(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Tenant", mux.Param(r, "tid"))
+			h.ServeHTTP(w, r)
+		})
+	})
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ps, _ := mux.ParamsFromContext(r.Context())
+		w.Write([]byte(mux.Param(r, "user-id") + " " + fmt.Sprint(len(ps))))
+	})
+	m.Get("tenants/:tid/users/:user-id", echo)
+	m.Get("users/", echo)
+	sub := mux.New("/", nil)
+	sub.Get("files/*path", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mux.Param(r, "tid") + " " + mux.Param(r, "path")))
+	}))
+	m.Mount("tenants/:tid/", sub)
+
+	tests := []struct {
+		path, body, tenant string
+	}{
+		{"/api/tenants/t1/users/u%201/", "u 1 2", "t1"},
+		{"/api/users/", " 0", ""},
+		{"/api/tenants/t1/files/a/b", "t1 a/b", "t1"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body || w.Header().Get("X-Tenant") != test.tenant {
+			t.Errorf("%s: body %q, tenant %q, want %q, %q", test.path, w.Body.String(), w.Header().Get("X-Tenant"), test.body, test.tenant)
+		}
+	}
+}
+
+/// TestTypedParams
+///////////////////
+func TestTypedParams(t *testing.T) {
+	ps := mux.Params{
+		{"id", "42"},
+		{"neg", "-7"},
+		{"ok", "true"},
+		{"day", "2024-05-01"},
+		{"uid", "0B5E0F6E-4c1e-4b8a-9d43-3c3f1b2a7e10"},
+		{"sort", "asc"},
+		{"bad", "x1"},
+	}
+
+	if v, err := ps.Int64("neg"); v != -7 || err != nil {
+		t.Errorf("Int64: %v, %v", v, err)
+	}
+	if v, err := ps.Uint("id"); v != 42 || err != nil {
+		t.Errorf("Uint: %v, %v", v, err)
+	}
+	if v, err := ps.Bool("ok"); !v || err != nil {
+		t.Errorf("Bool: %v, %v", v, err)
+	}
+	if v, err := ps.Time("day", "2006-01-02"); v.Month() != 5 || err != nil {
+		t.Errorf("Time: %v, %v", v, err)
+	}
+	if v, err := ps.UUID("uid"); v.String() != "0b5e0f6e-4c1e-4b8a-9d43-3c3f1b2a7e10" || err != nil {
+		t.Errorf("UUID: %v, %v", v, err)
+	}
+	if v, err := ps.Enum("sort", "asc", "desc"); v != "asc" || err != nil {
+		t.Errorf("Enum: %v, %v", v, err)
+	}
+
+	for _, f := range []func() error{
+		func() error { _, err := ps.Int64("bad"); return err },
+		func() error { _, err := ps.Uint("neg"); return err },
+		func() error { _, err := ps.Bool("bad"); return err },
+		func() error { _, err := ps.Time("bad", "2006-01-02"); return err },
+		func() error { _, err := ps.UUID("bad"); return err },
+		func() error { _, err := ps.Enum("bad", "asc", "desc"); return err },
+		func() error { _, err := ps.Int64("missing"); return err },
+	} {
+		err := f()
+		pe, ok := err.(*mux.ParamError)
+		if !ok {
+			t.Errorf("error %v, want a *mux.ParamError", err)
+			continue
+		}
+
+		w := httptest.NewRecorder()
+		pe.ServeHTTP(w, nil)
+		if w.Code != 400 || !strings.Contains(w.Body.String(), pe.Key) {
+			t.Errorf("%v: %d %q", err, w.Code, w.Body.String())
+		}
+	}
+	if _, err := ps.Int64("missing"); err.(*mux.ParamError).Err != mux.ErrParamMissing {
+		t.Errorf("missing: %v", err)
+	}
+}
+
+/// TestFold
+////////////
+func TestFold(t *testing.T) {
+	nfc := func(s string) string { return strings.Replace(s, "é", "é", -1) }
+	m := mux.New("/api/", nil, mux.WithCaseInsensitive(), mux.WithNormalization(nfc))
+	m.Handle("users/:name/", textHandler("user"))
+	m.Handle("files/:name.:ext/", textHandler("file"))
+	m.Handle("files/:name{[A-Z]+}.txt/", textHandler("upper text"))
+	m.Handle("codes/:code{[A-Z]+}/", textHandler("code"))
+	m.Handle("codes/:name/", textHandler("code name"))
+	m.Handle("café/", textHandler("cafe"))
+	sub := mux.New("/", nil, mux.WithCaseInsensitive())
+	sub.Handle("Docs/*path", textHandler("docs"))
+	m.Mount("static/", sub)
+
+	tests := []struct {
+		path, body string
+		captures   map[string]string
+	}{
+		{"/API/Users/Bob/", "user", map[string]string{"name": "Bob"}},
+		{"/api/FILES/Report.PDF/", "file", map[string]string{"name": "Report", "ext": "PDF"}},
+		{"/api/files/ABC.txt/", "upper text", map[string]string{"name": "ABC"}},
+		{"/api/files/ABC.TXT/", "upper text", map[string]string{"name": "ABC"}},
+		{"/api/files/abc.txt/", "file", map[string]string{"name": "abc", "ext": "txt"}},
+		{"/api/Codes/BOB/", "code", map[string]string{"code": "BOB"}},
+		{"/api/codes/bob/", "code name", map[string]string{"name": "bob"}},
+		{"/api/café/", "cafe", map[string]string{}},
+		{"/api/CAFÉ/", "cafe", map[string]string{}},
+		{"/api/Static/docs/A/b", "docs", map[string]string{"path": "A/b"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.path, w.Body.String(), test.body)
+		}
+		c := m.ServeHTTPWithContext(context.Background(), w, req)
+		if captures, _ := mux.FromContext(c); !sameMap(captures, test.captures) {
+			t.Errorf("%s: captures %v, want %v", test.path, captures, test.captures)
+		}
+	}
+
+	r := mux.New("/api/", nil, mux.WithCaseInsensitive(), mux.WithCanonicalRedirect())
+	r.Handle("Users/:name/", textHandler("user"))
+	for _, test := range []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/api/Users/Bob/", 200, ""},
+		{"/api/users/Bob/?x=1", 301, "/api/Users/Bob/?x=1"},
+		{"/API/Users/Bob/", 301, "/api/Users/Bob/"},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.path, nil)
+		r.ServeHTTP(w, req)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("%s: %d %q, want %d %q", test.path, w.Code, w.Header().Get("Location"), test.code, test.location)
+		}
+	}
+
+	c := mux.New("/", nil, mux.WithCaseInsensitive())
+	c.Handle("Users/", textHandler(""))
+	c.Handle("users/", textHandler(""))
+	if err := c.Build(); err == nil || !strings.HasPrefix(err.Error(), "mux: pattern ambiguous: users/, Users/") {
+		t.Errorf("Build: %v", err)
+	}
+}
+
+/// TestWhen
+////////////
+func TestWhen(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Get("users/", textHandler("v1"))
+	m.When(mux.MediaType("application/vnd.acme.v2+json")).Get("users/", textHandler("v2 accept"))
+	m.When(mux.Header("X-API-Version", "2")).Get("users/", textHandler("v2 header"))
+	m.When(mux.Query("v", "2")).Get("users/", textHandler("v2 query"))
+	m.When(mux.Query("v", "3")).Handle("users/", textHandler("v3 any"))
+	m.When(mux.HeaderRegexp("User-Agent", "^curl/")).Get("feeds/:page/", textHandler("curl"))
+	m.Handle("items/", textHandler("any"))
+	m.When(mux.Header("X-API-Version", "2")).Get("items/", textHandler("v2 items"))
+
+	tests := []struct {
+		method, path string
+		header       http.Header
+		code         int
+		body         string
+	}{
+		{"GET", "/api/users/", nil, 200, "v1"},
+		{"GET", "/api/users/", http.Header{"Accept": {"text/html, application/vnd.acme.v2+json;q=0.9"}}, 200, "v2 accept"},
+		{"GET", "/api/users/", http.Header{"Accept": {"application/vnd.acme.v2+json;q=0"}}, 200, "v1"},
+		{"GET", "/api/users/", http.Header{"Accept": {"*/*"}}, 200, "v1"},
+		{"GET", "/api/users/", http.Header{"X-Api-Version": {"1, 2"}}, 200, "v2 header"},
+		{"GET", "/api/users/?v=2", nil, 200, "v2 query"},
+		{"GET", "/api/users/?v=3", nil, 200, "v1"},
+		{"POST", "/api/users/?v=3", nil, 200, "v3 any"},
+		{"POST", "/api/users/", nil, 406, ""},
+		{"GET", "/api/feeds/1/", http.Header{"User-Agent": {"curl/8.0"}}, 200, "curl"},
+		{"GET", "/api/feeds/1/", nil, 406, ""},
+		{"POST", "/api/feeds/1/", nil, 405, ""},
+		{"GET", "/api/items/", nil, 200, "any"},
+		{"GET", "/api/items/", http.Header{"X-Api-Version": {"2"}}, 200, "v2 items"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		for k, v := range test.header {
+			req.Header[k] = v
+		}
+		m.ServeHTTP(w, req)
+		if w.Code != test.code || test.code == 200 && w.Body.String() != test.body {
+			t.Errorf("%s %s %v: %d %q, want %d %q", test.method, test.path, test.header, w.Code, w.Body.String(), test.code, test.body)
+		}
+	}
+
+	var infos []string
+	for _, r := range m.Routes() {
+		if r.Pattern == "/api/users/" && r.Method == "GET" {
+			infos = append(infos, r.Matchers)
+		}
+	}
+	if want := []string{"", "accept application/vnd.acme.v2+json", `header X-Api-Version="2"`, "query v=2"}; !reflect.DeepEqual(infos, want) {
+		t.Errorf("Routes: matchers %q, want %q", infos, want)
+	}
+
+	d := mux.New("/", nil)
+	d.When(mux.Query("v", "2")).Get("users/", textHandler(""))
+	d.When(mux.Query("v", "2")).Get("users/", textHandler(""))
+	if err := d.Build(); err == nil || err.Error() != "mux: pattern existed: GET users/, query v=2" {
+		t.Errorf("Build: %v", err)
+	}
+}
+
+/// TestOptions
+///////////////
+func TestOptions(t *testing.T) {
+	app := &mux.CORS{Origins: []string{"https://app.example.com"}, Credentials: true, Expose: []string{"X-Total"}, MaxAge: time.Hour}
+	m := mux.New("/api/", nil, mux.WithCORS(&mux.CORS{Origins: []string{"*"}}))
+	m.Get("users/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total", "2")
+		w.Write([]byte("users"))
+	}))
+	m.CORS(app).Put("users/:id", textHandler("put user"))
+	m.Get("users/:id", textHandler("user"))
+	m.HandleMethod("HEAD", "feeds/", textHandler("head feeds"))
+	m.Get("feeds/", textHandler("feeds"))
+	m.HandleContext("items/", httpcontext.ContextHandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return c
+		}
+		w.Write([]byte("items"))
+		return c
+	}))
+	m.HandleMethodContext("GET", "orders/", httpcontext.ContextHandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Header().Set("X-Order", "1")
+		w.Write([]byte("orders"))
+		return c
+	}))
+	m.HandleMethod("OPTIONS", "files/", textHandler("options files"))
+	m.Post("files/", textHandler(""))
+	m.CORS(&mux.CORS{Origins: []string{"https://app.example.com", "*"}, Credentials: true}).Get("public/", textHandler("public"))
+
+	tests := []struct {
+		method, path string
+		header       http.Header
+		code         int
+		body         string
+		want         map[string]string // headers
+	}{
+		{"HEAD", "/api/users/", nil, 200, "", map[string]string{"X-Total": "2"}},
+		{"HEAD", "/api/feeds/", nil, 200, "head feeds", nil},
+		{"HEAD", "/api/items/", nil, 405, "Method not allowed\n", nil}, // any-method routes get HEAD as is
+		{"HEAD", "/api/orders/", nil, 200, "", map[string]string{"X-Order": "1"}},
+		{"OPTIONS", "/api/users/u1/", nil, 204, "", map[string]string{"Allow": "GET, HEAD, OPTIONS, PUT", "Access-Control-Allow-Origin": ""}},
+		{"OPTIONS", "/api/items/", nil, 204, "", map[string]string{"Allow": "DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT"}},
+		{"OPTIONS", "/api/files/", nil, 200, "options files", nil},
+		{"POST", "/api/feeds/", nil, 405, "Method not allowed\n", map[string]string{"Allow": "GET, HEAD, OPTIONS"}},
+
+		// preflight
+		{"OPTIONS", "/api/users/u1/", http.Header{
+			"Origin":                         {"https://app.example.com"},
+			"Access-Control-Request-Method":  {"PUT"},
+			"Access-Control-Request-Headers": {"Content-Type"},
+		}, 204, "", map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Methods":     "GET, HEAD, OPTIONS, PUT",
+			"Access-Control-Allow-Headers":     "Content-Type",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "3600",
+		}},
+		{"OPTIONS", "/api/users/u1/", http.Header{
+			"Origin":                        {"https://evil.example.com"},
+			"Access-Control-Request-Method": {"PUT"},
+		}, 204, "", map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"OPTIONS", "/api/users/u1/", http.Header{
+			"Origin":                        {"https://evil.example.com"},
+			"Access-Control-Request-Method": {"GET"},
+		}, 204, "", map[string]string{"Access-Control-Allow-Origin": "*"}},
+		{"OPTIONS", "/api/users/u1/", http.Header{
+			"Origin":                        {"https://evil.example.com"},
+			"Access-Control-Request-Method": {"DELETE"},
+		}, 204, "", map[string]string{"Access-Control-Allow-Origin": ""}},
+
+		// actual requests
+		{"PUT", "/api/users/u1/", http.Header{"Origin": {"https://app.example.com"}}, 200, "put user", map[string]string{
+			"Access-Control-Allow-Origin":   "https://app.example.com",
+			"Access-Control-Expose-Headers": "X-Total",
+			"Vary":                          "Origin",
+		}},
+		{"GET", "/api/users/", http.Header{"Origin": {"https://evil.example.com"}}, 200, "users", map[string]string{"Access-Control-Allow-Origin": "*"}},
+		{"GET", "/api/users/", nil, 200, "users", map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"GET", "/api/public/", http.Header{"Origin": {"https://evil.example.com"}}, 200, "public", map[string]string{
+			"Access-Control-Allow-Origin":      "*",
+			"Access-Control-Allow-Credentials": "",
+			"Vary":                             "",
+		}},
+		{"GET", "/api/public/", http.Header{"Origin": {"https://app.example.com"}}, 200, "public", map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+		}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		for k, v := range test.header {
+			req.Header[k] = v
+		}
+		m.ServeHTTP(w, req)
+		if w.Code != test.code || w.Body.String() != test.body {
+			t.Errorf("%s %s: %d %q, want %d %q", test.method, test.path, w.Code, w.Body.String(), test.code, test.body)
+		}
+		for k, v := range test.want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s %s: %s %q, want %q", test.method, test.path, k, got, v)
+			}
+		}
+	}
+
+	// HEAD keeps the writer flushable, and unwrappable.
+	s := mux.New("/", nil)
+	s.Get("events/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok {
+			t.Error("HEAD: no Unwrap")
+		}
+		w.Write([]byte("event"))
+		w.(http.Flusher).Flush()
+	}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("HEAD", "/events/", nil)
+	s.ServeHTTP(w, req)
+	if !w.Flushed || w.Body.Len() != 0 {
+		t.Errorf("HEAD: flushed %v, body %q", w.Flushed, w.Body.String())
+	}
+}
+
+/// TestMetadata
+////////////////
+func TestMetadata(t *testing.T) {
+	m := mux.New("/api/", nil)
+	m.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scope, ok := mux.Meta(r, "scope").(string); ok {
+				w.Header().Set("X-Scope", scope)
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	owned := m.Meta(mux.Metadata{"owner": "accounts", "scope": "users:read"})
+	owned.Get("users/", textHandler("users"))
+	owned.Meta(mux.Metadata{"scope": "users:write"}).Put("users/:id", textHandler("put user"))
+	owned.HandleContext("users/:id/feeds/", httpcontext.ContextHandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		md, _ := mux.MetadataFromContext(c)
+		w.Write([]byte(md["owner"].(string)))
+		return c
+	}))
+	m.Get("feeds/", textHandler("feeds"))
+
+	tests := []struct {
+		method, path string
+		body, scope  string
+	}{
+		{"GET", "/api/users/", "users", "users:read"},
+		{"HEAD", "/api/users/", "", "users:read"},
+		{"PUT", "/api/users/u1/", "put user", "users:write"},
+		{"GET", "/api/users/u1/feeds/", "accounts", "users:read"},
+		{"GET", "/api/feeds/", "feeds", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		m.ServeHTTP(w, req)
+		if w.Body.String() != test.body || w.Header().Get("X-Scope") != test.scope {
+			t.Errorf("%s %s: body %q, scope %q, want %q %q", test.method, test.path, w.Body.String(), w.Header().Get("X-Scope"), test.body, test.scope)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/api/users/", nil)
+	c := m.ServeHTTPWithContext(context.Background(), httptest.NewRecorder(), req)
+	if md, ok := mux.MetadataFromContext(c); !ok || md["owner"] != "accounts" {
+		t.Errorf("ServeHTTPWithContext: metadata %v", md)
+	}
+
+	var b bytes.Buffer
+	for _, r := range m.Routes() {
+		fmt.Fprintf(&b, "%s %s %v\n", r.Method, r.Pattern, r.Metadata)
+	}
+	want := `GET /api/feeds/ map[]
+GET /api/users/ map[owner:accounts scope:users:read]
+PUT /api/users/:id/ map[owner:accounts scope:users:write]
+ /api/users/:id/feeds/ map[owner:accounts scope:users:read]
+`
+	if b.String() != want {
+		t.Errorf("Routes:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+/// TestStatic
+//////////////
+func TestStatic(t *testing.T) {
+	root := fstest.MapFS{
+		"app.js":          {Data: []byte("console.log(1)")},
+		"app.js.gz":       {Data: []byte("gzipped")},
+		"app.js.br":       {Data: []byte("brotli")},
+		"style.css":       {Data: []byte("body{}")},
+		"index.html":      {Data: []byte("<html>")},
+		"docs/index.html": {Data: []byte("docs")},
+	}
+	m := mux.New("/", nil)
+	m.Static("static/", root)
+	m.Static("app/", root, mux.StaticSPA("index.html"), mux.StaticCacheControl("no-cache"))
+	m.Group("tenants/:tid", func(g *mux.Group) {
+		g.Use(traceMiddleware("tenant"))
+		g.Static("files/", root)
+	})
+	m.Use(traceMiddleware("use"))
+
+	tests := []struct {
+		method, path string
+		header       http.Header
+		code         int
+		body         string
+		want         map[string]string // headers
+	}{
+		{"GET", "/static/style.css", nil, 200, "body{}", map[string]string{"Content-Type": "text/css; charset=utf-8", "Vary": ""}},
+		{"GET", "/static/app.js", nil, 200, "console.log(1)", map[string]string{"Content-Type": "text/javascript; charset=utf-8", "Vary": "Accept-Encoding"}},
+		{"GET", "/static/app.js", http.Header{"Accept-Encoding": {"gzip"}}, 200, "gzipped", map[string]string{"Content-Encoding": "gzip", "Content-Type": "text/javascript; charset=utf-8"}},
+		{"GET", "/static/app.js", http.Header{"Accept-Encoding": {"gzip, br"}}, 200, "brotli", map[string]string{"Content-Encoding": "br"}},
+		{"GET", "/static/app.js", http.Header{"Accept-Encoding": {"br;q=0, gzip"}}, 200, "gzipped", map[string]string{"Content-Encoding": "gzip"}},
+		{"GET", "/static/app.js", http.Header{"Accept-Encoding": {"*, br;q=0"}}, 200, "gzipped", map[string]string{"Content-Encoding": "gzip"}},
+		{"GET", "/static/app.js", http.Header{"Accept-Encoding": {"*"}}, 200, "brotli", map[string]string{"Content-Encoding": "br"}},
+		{"GET", "/static/app.js", http.Header{"Range": {"bytes=0-6"}}, 206, "console", map[string]string{"Content-Range": "bytes 0-6/14"}},
+		{"HEAD", "/static/app.js", nil, 200, "", nil},
+		{"POST", "/static/app.js", nil, 405, "Method not allowed\n", map[string]string{"Allow": "GET, HEAD, OPTIONS"}},
+		{"GET", "/static/", nil, 200, "<html>", nil},
+		{"GET", "/static/docs", nil, 301, "", map[string]string{"Location": "docs/"}},
+		{"GET", "/static/docs?x=1", nil, 301, "", map[string]string{"Location": "docs/?x=1"}},
+		{"GET", "/static/docs/", nil, 200, "docs", nil},
+		{"GET", "/static/missing", nil, 404, "404 page not found\n", map[string]string{"X-Trace": "use"}},
+		{"GET", "/static/../../app.js", nil, 200, "console.log(1)", nil}, // cleaned within root
+		{"GET", "/app/users/1", nil, 200, "<html>", map[string]string{"Cache-Control": "no-cache"}},
+		{"GET", "/app/missing.js", nil, 404, "404 page not found\n", map[string]string{"X-Trace": "use"}},
+		{"GET", "/tenants/t1/files/style.css", nil, 200, "body{}", nil},
+		{"GET", "/tenants/t1/files/missing", nil, 404, "404 page not found\n", map[string]string{"X-Trace": "use"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		for k, v := range test.header {
+			req.Header[k] = v
+		}
+		m.ServeHTTP(w, req)
+		if w.Code != test.code || test.code != 301 && w.Body.String() != test.body {
+			t.Errorf("%s %s %v: %d %q, want %d %q", test.method, test.path, test.header, w.Code, w.Body.String(), test.code, test.body)
+		}
+		for k, v := range test.want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s %s %v: %s %q, want %q", test.method, test.path, test.header, k, got, v)
+			}
+		}
+		if vary := w.Header()["Vary"]; len(vary) > 1 {
+			t.Errorf("%s %s %v: Vary %q", test.method, test.path, test.header, vary)
+		}
+	}
+
+	// a missing file is a 404 through the middleware of its route.
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/tenants/t1/files/missing", nil)
+	m.ServeHTTP(w, req)
+	if trace := w.Header()["X-Trace"]; w.Code != 404 || strings.Join(trace, " ") != "use tenant" {
+		t.Errorf("missing: %d, trace %q", w.Code, trace)
+	}
+
+	// a path starting with "//" is not redirected to another host.
+	tm := mux.New("/", nil, mux.WithPathPolicy(mux.Tolerant))
+	tm.Static("assets/", root)
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/", nil)
+	req.URL.Path = "//evil.com/../assets/docs"
+	tm.ServeHTTP(w, req)
+	if w.Code != 301 || w.Header().Get("Location") != "docs/" {
+		t.Errorf("%s: %d %q", req.URL.Path, w.Code, w.Header().Get("Location"))
+	}
+
+	// strong ETags, of the content.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/static/style.css", nil)
+	m.ServeHTTP(w, req)
+	tag := w.Header().Get("ETag")
+	if !strings.HasPrefix(tag, `"`) || len(tag) != 34 {
+		t.Fatalf("ETag %q", tag)
+	}
+	w = httptest.NewRecorder()
+	req.Header.Set("If-None-Match", tag)
+	m.ServeHTTP(w, req)
+	if w.Code != 304 {
+		t.Errorf("If-None-Match: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/static/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	m.ServeHTTP(w, req)
+	if w.Header().Get("ETag") == tag || w.Header().Get("ETag") == "" {
+		t.Errorf("ETag of app.js.gz %q", w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,101 @@
+package mux
+
+import (
+	"strings"
+)
+
+// optional parses seg as an optional capture, ":page?" or ":page{int}?=1",
+// returning its capture node and default value.
+func optional(pattern, seg string) (w *node, def string, hasDef, ok bool) {
+	if !strings.HasPrefix(seg, ":") {
+		return nil, "", false, false
+	}
+	j := 1
+	for j < len(seg) && isNameChar(seg[j]) {
+		j++
+	}
+	if j < len(seg) && seg[j] == '{' {
+		depth := 0
+		for j < len(seg) {
+			if seg[j] == '{' {
+				depth++
+			} else if seg[j] == '}' {
+				depth--
+			}
+			j++
+			if depth == 0 {
+				break
+			}
+		}
+	}
+	if j == len(seg) || seg[j] != '?' {
+		return nil, "", false, false
+	}
+
+	switch rest := seg[j+1:]; {
+	case rest == "":
+	case rest[0] == '=':
+		def, hasDef = rest[1:], true
+	default:
+		return nil, "", false, false
+	}
+
+	w = newWild(pattern, seg[:j])
+	if hasDef && w.re != nil && !w.re.MatchString(def) {
+		panic(&Conflict{Problem: "default does not satisfy its constraint", Pattern: pattern})
+	}
+	return w, def, hasDef, true
+}
+
+// expand returns the patterns a cleaned pattern stands for, the longest
+// first, and for each the defaults of the optional captures it lacks.
+// Optional captures must be the trailing segments:
+//
+//	"archive/:year?/:month?=1/" -> "archive/:year/:month/", nil
+//	                               "archive/:year/", month=1
+//	                               "archive/", month=1
+func expand(pattern string) (patterns []string, defaults []Params) {
+	if strings.IndexByte(pattern, '?') == -1 {
+		return []string{pattern}, []Params{nil}
+	}
+
+	segs := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	first := -1
+	var opts Params // the defaults of the optional segments, "" if none
+	var hasDefs []bool
+	for i, seg := range segs {
+		w, def, hasDef, ok := optional(pattern, seg)
+		if !ok {
+			if first != -1 {
+				panic(&Conflict{Problem: "optional segments must be the last ones", Pattern: pattern})
+			}
+			continue
+		}
+		if first == -1 {
+			first = i
+		}
+		segs[i] = w.path
+		opts = append(opts, Capture{w.key, def})
+		hasDefs = append(hasDefs, hasDef)
+	}
+	if first == -1 {
+		return []string{pattern}, []Params{nil}
+	}
+
+	for n := len(segs); n >= first; n-- {
+		p := strings.Join(segs[:n], "/")
+		if p != "" {
+			p += "/"
+		}
+
+		var ds Params
+		for i, o := range opts[n-first:] {
+			if hasDefs[n-first+i] {
+				ds = append(ds, o)
+			}
+		}
+		patterns = append(patterns, p)
+		defaults = append(defaults, ds)
+	}
+	return patterns, defaults
+}
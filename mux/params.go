@@ -0,0 +1,199 @@
+package mux
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture is one capture of a match.
+type Capture struct {
+	Key   string
+	Value string
+}
+
+// Params are the captures of a match, in path order.
+type Params []Capture
+
+// Get returns the value captured as key.
+func (ps Params) Get(key string) (string, bool) {
+	for _, p := range ps {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Map returns the captures as a map, allocating it.
+func (ps Params) Map() map[string]string {
+	m := make(map[string]string, len(ps))
+	for _, p := range ps {
+		m[p.Key] = p.Value
+	}
+	return m
+}
+
+// ErrParamMissing is the Err of a ParamError for a capture not there.
+var ErrParamMissing = errors.New("missing")
+
+// ParamError is the error of the typed accessors of Params: the capture
+// Key is missing, or its Value is not of Type. It is an http.Handler
+// answering 400 Bad Request, as rest does for the errors of an rpc that
+// are.
+type ParamError struct {
+	Key   string
+	Value string
+	Type  string // "int64", "uuid", ...
+	Err   error  // ErrParamMissing, or the parse error
+}
+
+func (e *ParamError) Error() string {
+	return "mux: " + e.message()
+}
+
+func (e *ParamError) message() string {
+	if e.Err == ErrParamMissing {
+		return fmt.Sprintf("capture %q missing", e.Key)
+	}
+	return fmt.Sprintf("capture %q: %q is not a valid %s", e.Key, e.Value, e.Type)
+}
+
+func (e *ParamError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Bad request: "+e.message(), http.StatusBadRequest)
+}
+
+func (ps Params) value(key, typ string) (string, error) {
+	v, ok := ps.Get(key)
+	if !ok {
+		return "", &ParamError{Key: key, Type: typ, Err: ErrParamMissing}
+	}
+	return v, nil
+}
+
+// Int64 returns the capture key as a decimal int64.
+func (ps Params) Int64(key string) (int64, error) {
+	v, err := ps.value(key, "int64")
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, &ParamError{key, v, "int64", err}
+	}
+	return i, nil
+}
+
+// Uint returns the capture key as a decimal uint64.
+func (ps Params) Uint(key string) (uint64, error) {
+	v, err := ps.value(key, "uint")
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, &ParamError{key, v, "uint", err}
+	}
+	return i, nil
+}
+
+// Bool returns the capture key as strconv.ParseBool does.
+func (ps Params) Bool(key string) (bool, error) {
+	v, err := ps.value(key, "bool")
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &ParamError{key, v, "bool", err}
+	}
+	return b, nil
+}
+
+// Time returns the capture key as a time in layout, see time.Parse.
+func (ps Params) Time(key, layout string) (time.Time, error) {
+	v, err := ps.value(key, "time")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, v)
+	if err != nil {
+		return time.Time{}, &ParamError{key, v, "time", err}
+	}
+	return t, nil
+}
+
+// UUID is a UUID, as captured by ":id{uuid}".
+type UUID [16]byte
+
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+var errUUID = errors.New("want xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx")
+
+// UUID returns the capture key as a UUID in its hyphenated form, in
+// either case.
+func (ps Params) UUID(key string) (UUID, error) {
+	var u UUID
+	v, err := ps.value(key, "uuid")
+	if err != nil {
+		return u, err
+	}
+	if len(v) != 36 || v[8] != '-' || v[13] != '-' || v[18] != '-' || v[23] != '-' {
+		return u, &ParamError{key, v, "uuid", errUUID}
+	}
+	if _, err := hex.Decode(u[:], []byte(v[0:8]+v[9:13]+v[14:18]+v[19:23]+v[24:])); err != nil {
+		return u, &ParamError{key, v, "uuid", err}
+	}
+	return u, nil
+}
+
+// Enum returns the capture key, if it is one of values.
+//
+//	sort, err := ps.Enum("sort", "asc", "desc")
+func (ps Params) Enum(key string, values ...string) (string, error) {
+	typ := "one of " + strings.Join(values, ", ")
+	v, err := ps.value(key, typ)
+	if err != nil {
+		return "", err
+	}
+	for _, value := range values {
+		if v == value {
+			return v, nil
+		}
+	}
+	return "", &ParamError{key, v, typ, fmt.Errorf("not %s", typ)}
+}
+
+// match works on pooled Params, so that matching itself allocates nothing.
+var paramsPool = sync.Pool{
+	New: func() interface{} {
+		ps := make(Params, 0, 8)
+		return &ps
+	},
+}
+
+func getParams() *Params {
+	return paramsPool.Get().(*Params)
+}
+
+func putParams(ps *Params) {
+	*ps = (*ps)[:0]
+	paramsPool.Put(ps)
+}
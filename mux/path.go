@@ -0,0 +1,179 @@
+package mux
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+const upperhex = "0123456789ABCDEF"
+
+func ishex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// requestPath returns the escaped path of r with everything decoded but
+// "%2F" and "%25", which is what routes are matched on: a slash separates
+// segments, an encoded one does not. Literal segments are compared as
+// registered, unicode ones included, and capture values are decoded by
+// unescapeValue. There is no allocation unless the path has a '%'.
+func requestPath(r *http.Request) string {
+	p := r.URL.EscapedPath()
+	i := strings.IndexByte(p, '%')
+	if i == -1 {
+		return p
+	}
+
+	buf := make([]byte, 0, len(p))
+	buf = append(buf, p[:i]...)
+	for ; i < len(p); i++ {
+		if p[i] == '%' && i+2 < len(p) && ishex(p[i+1]) && ishex(p[i+2]) {
+			c := unhex(p[i+1])<<4 | unhex(p[i+2])
+			if c == '/' || c == '%' {
+				buf = append(buf, '%', upperhex[c>>4], upperhex[c&15])
+			} else {
+				buf = append(buf, c)
+			}
+			i += 2
+			continue
+		}
+		buf = append(buf, p[i])
+	}
+	return string(buf)
+}
+
+// unescapeValue decodes a capture of a requestPath.
+func unescapeValue(v string) string {
+	if strings.IndexByte(v, '%') == -1 {
+		return v
+	}
+	v = strings.Replace(v, "%2F", "/", -1)
+	return strings.Replace(v, "%25", "%", -1)
+}
+
+// escapePath escapes a requestPath back for a Location header, keeping
+// its "%2F" and "%25".
+func escapePath(p string) string {
+	var buf []byte
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("-._~!$&'()*+,;=:@/%", c) != -1 {
+			if buf != nil {
+				buf = append(buf, c)
+			}
+			continue
+		}
+		if buf == nil {
+			buf = append(make([]byte, 0, len(p)+8), p[:i]...)
+		}
+		buf = append(buf, '%', upperhex[c>>4], upperhex[c&15])
+	}
+	if buf == nil {
+		return p
+	}
+	return string(buf)
+}
+
+// PathPolicy says what is done with a request path that is not in its
+// canonical form: cleaned of "//", "." and "..", and with the trailing
+// slash of the route it matches ("users/:id" is registered as "users/:id/").
+type PathPolicy int
+
+const (
+	// Strict matches the path as it is, the default.
+	Strict PathPolicy = iota
+	// Redirect answers 301 (308 but for GET and HEAD) to the canonical
+	// path, query string kept.
+	Redirect
+	// Tolerant serves the canonical path without redirecting.
+	Tolerant
+)
+
+// WithPathPolicy sets the PathPolicy of a Mux. A mounted mux follows the
+// policy of its topmost parent.
+func WithPathPolicy(policy PathPolicy) Option {
+	return func(mux *Mux) {
+		mux.policy = policy
+	}
+}
+
+// cleanPath is path.Clean keeping the trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	c := path.Clean(p)
+	if p[len(p)-1] == '/' && c != "/" {
+		// no allocation when p was clean already.
+		if len(p) == len(c)+1 && p[:len(c)] == c {
+			return p
+		}
+		c += "/"
+	}
+	return c
+}
+
+func toggleSlash(p string) string {
+	if p == "/" {
+		return p
+	}
+	if p[len(p)-1] == '/' {
+		return p[:len(p)-1]
+	}
+	return p + "/"
+}
+
+// canonical matches c, the cleaned requestPath rp of r, or else c with its
+// trailing slash toggled, then redirects to or serves the first one that
+// matches. If none does, it returns notFound, or else the 404 handler of
+// c: that of a mounted mux if c is below one.
+func (mux *Mux) canonical(t *tree, r *http.Request, rp, c string, notFound http.Handler, ps *Params) http.Handler {
+	for _, p := range [2]string{c, toggleSlash(c)} {
+		if p == rp {
+			continue
+		}
+
+		*ps = (*ps)[:0]
+		h, ok := mux.matchPath(t, r, p, ps)
+		if !ok {
+			if notFound == nil {
+				notFound = h
+			}
+			continue
+		}
+		if mux.policy == Tolerant {
+			return h
+		}
+
+		*ps = (*ps)[:0]
+		return mux.redirect(t, r, p)
+	}
+
+	*ps = (*ps)[:0]
+	return notFound
+}
+
+// redirect redirects r to the path p, keeping the query string, through
+// the middleware of t.
+func (mux *Mux) redirect(t *tree, r *http.Request, p string) http.Handler {
+	u := escapePath(p)
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+	code := http.StatusPermanentRedirect
+	if r.Method == "GET" || r.Method == "HEAD" {
+		code = http.StatusMovedPermanently
+	}
+	return chain(t.mws, http.RedirectHandler(u, code))
+}
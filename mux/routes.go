@@ -0,0 +1,214 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RouteInfo describes a registered route, see Mux.Routes.
+type RouteInfo struct {
+	Host       string   `json:"host,omitempty"`
+	Pattern    string   `json:"pattern"`          // prefix included, "/api/users/:id/"
+	Method     string   `json:"method,omitempty"` // "" for any method
+	Name       string   `json:"name,omitempty"`
+	Matchers   string   `json:"matchers,omitempty"`   // of a variant, see Mux.When
+	Handler    string   `json:"handler"`              // its type, or function name
+	Middleware []string `json:"middleware,omitempty"` // function names, outermost first
+	Metadata   Metadata `json:"metadata,omitempty"`   // see Mux.Meta, not to be modified
+}
+
+// Routes returns the routes of mux, those of the muxes mounted on it and
+// of its host muxes included, sorted by host, pattern, method and
+// matchers. A pattern with optional captures has a route for each of its
+// forms.
+func (mux *Mux) Routes() []RouteInfo {
+	mux.mu.Lock()
+	outer := mux.outer
+	mux.mu.Unlock()
+
+	routes := mux.appendRoutes(nil, "", mux.prefix, outer)
+	sort.Sort(byRoute(routes))
+	return routes
+}
+
+// appendRoutes appends the routes of mux to routes, its patterns below
+// base, with the middleware the way buildWith composes it.
+func (mux *Mux) appendRoutes(routes []RouteInfo, host, base string, outer []Middleware) []RouteInfo {
+	type sub struct {
+		mux        *Mux
+		host, base string
+		outer      []Middleware
+	}
+	var subs []sub
+
+	mux.mu.Lock()
+	names := make(map[string]string, len(mux.names))
+	for name, t := range mux.names {
+		names[t.pattern] = name
+	}
+
+	chains := newChains(outer, mux.mws)
+	for pattern, rts := range mux.m {
+		for method, rt := range rts {
+			if mt, ok := rt.h.(mount); ok {
+				base := base + strings.TrimSuffix(pattern, "*"+mountKey+"/")
+				subs = append(subs, sub{mt.sub, host, base, append(chains.of(pattern), rt.mws...)})
+				continue
+			}
+
+			for ; rt != nil; rt = rt.alt {
+				info := RouteInfo{
+					Host:     host,
+					Pattern:  base + pattern,
+					Method:   method,
+					Matchers: matchersString(rt.ms),
+					Handler:  handlerName(rt.h),
+					Metadata: rt.md,
+				}
+				if method == "" {
+					info.Name = names[pattern]
+				}
+				for _, mw := range append(chains.of(pattern), rt.mws...) {
+					info.Middleware = append(info.Middleware, funcName(mw))
+				}
+				routes = append(routes, info)
+			}
+		}
+	}
+	for _, hm := range mux.hosts {
+		subs = append(subs, sub{hm.mux, hm.pattern, base, chains.of("")})
+	}
+	mux.mu.Unlock()
+
+	for _, s := range subs {
+		routes = s.mux.appendRoutes(routes, s.host, s.base, s.outer)
+	}
+	return routes
+}
+
+func handlerName(h http.Handler) string {
+	if h == nil {
+		return "<nil>"
+	}
+	var v interface{} = h
+	if cr, ok := h.(contextRoute); ok {
+		v = cr.h
+	}
+	if reflect.ValueOf(v).Kind() == reflect.Func {
+		return funcName(v)
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// funcName returns the name of the function f, "pkg.New.func1" for a
+// closure.
+func funcName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if v.IsNil() {
+		return "<nil>"
+	}
+	if fn := runtime.FuncForPC(v.Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return v.Type().String()
+}
+
+type byRoute []RouteInfo
+
+func (s byRoute) Len() int      { return len(s) }
+func (s byRoute) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byRoute) Less(i, j int) bool {
+	if s[i].Host != s[j].Host {
+		return s[i].Host < s[j].Host
+	}
+	if s[i].Pattern != s[j].Pattern {
+		return s[i].Pattern < s[j].Pattern
+	}
+	if s[i].Method != s[j].Method {
+		return s[i].Method < s[j].Method
+	}
+	return s[i].Matchers < s[j].Matchers
+}
+
+// WriteJSON writes routes as an indented JSON array, stable for the same
+// routes, to be diffed.
+func WriteJSON(w io.Writer, routes []RouteInfo) error {
+	if routes == nil {
+		routes = []RouteInfo{}
+	}
+	b, err := json.MarshalIndent(routes, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// WriteDOT writes routes as a Graphviz digraph, a node per segment, the
+// ones with handlers listing them:
+//
+//	m.Routes() -> WriteDOT -> dot -Tsvg
+func WriteDOT(w io.Writer, routes []RouteInfo) error {
+	labels := make(map[string]string) // node id -> segment
+	handlers := make(map[string][]string)
+	edges := make(map[string]bool) // "from\x00to"
+
+	for _, r := range routes {
+		id := r.Host + "/"
+		labels[id] = id
+		path := strings.TrimPrefix(r.Pattern, "/")
+		for path != "" {
+			i := strings.IndexByte(path, '/') + 1
+			if i == 0 {
+				i = len(path)
+			}
+			child := id + path[:i]
+			labels[child] = path[:i]
+			edges[id+"\x00"+child] = true
+			id, path = child, path[i:]
+		}
+
+		method := r.Method
+		if method == "" {
+			method = "ANY"
+		}
+		if r.Matchers != "" {
+			method += " " + r.Matchers
+		}
+		handlers[id] = append(handlers[id], method+" "+r.Handler)
+	}
+
+	ids := make([]string, 0, len(labels))
+	for id := range labels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	pairs := make([]string, 0, len(edges))
+	for edge := range edges {
+		pairs = append(pairs, edge)
+	}
+	sort.Strings(pairs)
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph mux {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, id := range ids {
+		label := strings.Join(append([]string{labels[id]}, handlers[id]...), "\n")
+		fmt.Fprintf(&buf, "\t%s [label=%s];\n", strconv.Quote(id), strconv.Quote(label))
+	}
+	for _, edge := range pairs {
+		i := strings.IndexByte(edge, 0)
+		fmt.Fprintf(&buf, "\t%s -> %s;\n", strconv.Quote(edge[:i]), strconv.Quote(edge[i+1:]))
+	}
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
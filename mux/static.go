@@ -0,0 +1,250 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// staticKey names the catch-all a Static pattern serves files through.
+const staticKey = "_static"
+
+// StaticOption configures a Static route.
+type StaticOption func(*fileServer)
+
+// StaticSPA serves index, a file of the root, for the paths with no file
+// nor extension, as a single page application routes them itself.
+func StaticSPA(index string) StaticOption {
+	return func(s *fileServer) {
+		s.spa = index
+	}
+}
+
+// StaticCacheControl sets the Cache-Control header of the files served.
+func StaticCacheControl(value string) StaticOption {
+	return func(s *fileServer) {
+		s.cacheControl = value
+	}
+}
+
+// Static serves the files of root below pattern, which may have captures,
+// for GET and HEAD; os.DirFS(dir) serves a directory:
+//
+//	m.Static("assets/", os.DirFS("public"))
+//	m.Static("app/", dist, mux.StaticSPA("index.html"))
+//
+// A directory is served its index.html, and redirected to the path ending
+// with a slash first. Files have a strong ETag, of their content, and
+// Range, If-None-Match and If-Modified-Since requests are answered as by
+// http.ServeContent. A file with a ".br" or ".gz" sibling is served the
+// sibling, with its Content-Encoding, to a client accepting it. A missing
+// file gets the notFound handler of mux, through the middleware of the
+// route like any response of it.
+func (mux *Mux) Static(pattern string, root fs.FS, opts ...StaticOption) {
+	mux.handle("GET", cleanPattern(pattern)+"*"+staticKey, &route{h: mux.newStatic(root, opts)})
+}
+
+func (g *Group) Static(pattern string, root fs.FS, opts ...StaticOption) {
+	g.mux.handle("GET", g.pattern(pattern)+"*"+staticKey, g.route(g.mux.newStatic(root, opts)))
+}
+
+func (mux *Mux) newStatic(root fs.FS, opts []StaticOption) *fileServer {
+	s := &fileServer{root: root, notFound: mux.notFound, etags: make(map[string]etag)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// fileServer is the handler of a Static pattern.
+type fileServer struct {
+	root         fs.FS
+	spa          string
+	cacheControl string
+	notFound     http.Handler // of the mux, unchained: s is in the chain of its route
+
+	mu    sync.Mutex
+	etags map[string]etag // file name -> its last ETag
+}
+
+// etag is the ETag of a file, as long as its modification time and size
+// are the same.
+type etag struct {
+	modTime time.Time
+	size    int64
+	tag     string
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + Param(r, staticKey))[1:]
+	if name == "" {
+		name = "."
+	}
+
+	f, fi, err := s.open(name)
+	if err == nil && fi.IsDir() {
+		f.Close()
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// relative, as r.URL.Path may not be the path matched.
+			u := path.Base(name) + "/"
+			if r.URL.RawQuery != "" {
+				u += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", u)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		name = path.Join(name, "index.html")
+		f, fi, err = s.open(name)
+		if err == nil && fi.IsDir() {
+			f.Close()
+			err = fs.ErrNotExist
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) && s.spa != "" && path.Ext(name) == "" {
+		name = s.spa
+		f, fi, err = s.open(name)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		s.notFound.ServeHTTP(w, r)
+		return
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// the type is that of name, whatever sibling is served.
+	if typ := mime.TypeByExtension(path.Ext(name)); typ != "" {
+		w.Header().Set("Content-Type", typ)
+	}
+	if s.cacheControl != "" {
+		w.Header().Set("Cache-Control", s.cacheControl)
+	}
+	vary := false
+	for _, enc := range [2]struct{ coding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+		cf, cfi, err := s.open(name + enc.ext)
+		if err != nil {
+			continue
+		}
+		if cfi.IsDir() {
+			cf.Close()
+			continue
+		}
+		if !vary {
+			w.Header().Add("Vary", "Accept-Encoding")
+			vary = true
+		}
+		if !acceptsEncoding(r, enc.coding) {
+			cf.Close()
+			continue
+		}
+		defer cf.Close()
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Header().Set("Content-Encoding", enc.coding)
+		s.serve(w, r, name+enc.ext, cf, cfi)
+		return
+	}
+	s.serve(w, r, name, f, fi)
+}
+
+func (s *fileServer) open(name string) (fs.File, fs.FileInfo, error) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// serve serves f, the file name, with its ETag.
+func (s *fileServer) serve(w http.ResponseWriter, r *http.Request, name string, f fs.File, fi fs.FileInfo) {
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+
+	tag, err := s.etag(name, fi, content)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", tag)
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// etag returns the ETag of the file name, hashing content if it changed
+// since the last time.
+func (s *fileServer) etag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	s.mu.Lock()
+	e, ok := s.etags[name]
+	s.mu.Unlock()
+	if ok && e.modTime.Equal(fi.ModTime()) && e.size == fi.Size() {
+		return e.tag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	e = etag{fi.ModTime(), fi.Size(), strconv.Quote(hex.EncodeToString(h.Sum(nil)[:16]))}
+
+	s.mu.Lock()
+	s.etags[name] = e
+	s.mu.Unlock()
+	return e.tag, nil
+}
+
+// acceptsEncoding tells whether r accepts the content coding, with a q
+// above 0. An entry for coding takes precedence over a "*" one.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	star := -1.0 // the q of "*", if any
+	for _, accept := range r.Header["Accept-Encoding"] {
+		for _, accept := range strings.Split(accept, ",") {
+			params := strings.Split(accept, ";")
+			c := strings.TrimSpace(params[0])
+			if c != coding && c != "*" {
+				continue
+			}
+			q := 1.0
+			for _, p := range params[1:] {
+				if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+					q, _ = strconv.ParseFloat(p[2:], 64)
+				}
+			}
+			if c == coding {
+				return q > 0
+			}
+			star = q
+		}
+	}
+	return star > 0
+}
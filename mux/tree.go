@@ -0,0 +1,514 @@
+package mux
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The routes are kept in a compressed radix tree. Static nodes hold a
+// fragment of pattern text, shared by all the patterns below them, and
+// are found by their first byte in indices. Capture nodes hold one whole
+// segment (":id", ":id{int}", ":name.:ext") or the rest of the path
+// ("*filepath") and are tried after the static child, in the order of
+// node.less.
+//
+// "users/", "users/:id/", "users/:id/feeds/", "uploads/" make:
+//
+//	u
+//		sers/(h)
+//			:id
+//				/(h)
+//					feeds/(h)
+//		ploads/(h)
+
+type nodeKind int
+
+// in the order siblings are tried.
+const (
+	static     nodeKind = iota // users/
+	mixed                      // :name.:ext
+	constraint                 // :id{int}
+	param                      // :id
+	catchAll                   // *filepath
+)
+
+type node struct {
+	path       string // static text, or the capture segment
+	pattern    string // capture nodes: the first pattern through them
+	kind       nodeKind
+	key        string // capture name, without ':' or '*'
+	constraint string // ":id{[0-9]+}" -> "[0-9]+", the whole regexp for mixed
+	re         *regexp.Regexp
+	parts      []*node        // mixed: the literals and captures, in order
+	groups     []int          // mixed: the submatch of each capture in re
+	rawRe      *regexp.Regexp // mixed, when folding: re for the path as requested, see Mux.caseless
+
+	indices string  // first byte of each statics
+	statics []*node // static children
+	wilds   []*node // capture children, ordered by less
+
+	route         string                  // the pattern of the handlers
+	segs          []*node                 // of route, when folding, see Mux.foldSegs
+	hs            map[string]http.Handler // method -> handler, "" for any method
+	defaults      map[string]Params       // method -> defaults of optional captures, if any
+	variants      map[string][]variant    // method -> routes with matchers, if any
+	notAllowed    http.Handler
+	notAcceptable http.Handler
+	mount         *Mux
+}
+
+// named constraints usable as ":id{int}".
+var constraints = map[string]string{
+	"int":   `-?[0-9]+`,
+	"uint":  `[0-9]+`,
+	"hex":   `[0-9a-fA-F]+`,
+	"alpha": `[a-zA-Z]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
+// newWild parses seg, a capture segment of pattern, and compiles its
+// constraint if any. A constraint is a named one or a regexp that must
+// match the whole segment; it can not contain '/', as patterns are split
+// at it, nor be empty, and it ends the capture.
+func newWild(pattern, seg string) *node {
+	n := &node{path: seg}
+	switch seg[0] {
+	case '*':
+		n.kind = catchAll
+		n.key = seg[1:]
+	case ':':
+		n.kind = param
+		n.key = seg[1:]
+		if i := strings.IndexByte(seg, '{'); i != -1 {
+			// the '}' closing it must be the last byte.
+			depth, j := 0, i
+			for ; j < len(seg); j++ {
+				if seg[j] == '{' {
+					depth++
+				} else if seg[j] == '}' {
+					depth--
+				}
+				if depth == 0 {
+					break
+				}
+			}
+			switch {
+			case j == len(seg):
+				panic(&Conflict{Problem: "bad constraint", Pattern: pattern, Other: "unclosed { in " + seg})
+			case j != len(seg)-1:
+				panic(&Conflict{Problem: "bad constraint", Pattern: pattern, Other: "text after } in " + seg})
+			case j == i+1:
+				panic(&Conflict{Problem: "bad constraint", Pattern: pattern, Other: "empty constraint in " + seg})
+			}
+
+			n.kind = constraint
+			n.key = seg[1:i]
+			n.constraint = seg[i+1 : len(seg)-1]
+
+			expr, ok := constraints[n.constraint]
+			if !ok {
+				expr = n.constraint
+			}
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				panic(&Conflict{Problem: "bad constraint", Pattern: pattern, Other: err.Error()})
+			}
+			n.re = re
+		}
+	}
+	return n
+}
+
+func isNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-'
+}
+
+// splitSegment splits seg, a segment of pattern, into literals and
+// captures, or returns nil if it has no capture. A capture starts with ':'
+// at the start of seg or after a byte other than a letter, a digit or '_',
+// and its name is letters, digits, '_' and '-', not ending with '-':
+//
+//	":year-:month.csv" -> ":year", "-", ":month", ".csv"
+//	":id:batchGet"     -> ":id", ":batchGet"(literal)
+//	"v1:batch"         -> nil
+func splitSegment(pattern, seg string) (parts []*node) {
+	captured := false
+	lit := 0
+	for i := 0; i < len(seg); {
+		if seg[i] != ':' || i > 0 && isNameChar(seg[i-1]) && seg[i-1] != '-' || i+1 == len(seg) || !isNameChar(seg[i+1]) {
+			i++
+			continue
+		}
+		if lit < i {
+			parts = append(parts, &node{path: seg[lit:i]})
+		}
+
+		j := i + 1
+		for j < len(seg) && isNameChar(seg[j]) {
+			j++
+		}
+		for seg[j-1] == '-' {
+			j--
+		}
+		if j < len(seg) && seg[j] == '{' {
+			// the constraint, up to the matching '}'.
+			depth := 0
+			for j < len(seg) {
+				if seg[j] == '{' {
+					depth++
+				} else if seg[j] == '}' {
+					depth--
+				}
+				j++
+				if depth == 0 {
+					break
+				}
+			}
+		}
+		parts = append(parts, newWild(pattern, seg[i:j]))
+		captured = true
+		i, lit = j, j
+	}
+	if !captured {
+		return nil
+	}
+	if lit < len(seg) {
+		parts = append(parts, &node{path: seg[lit:]})
+	}
+	return parts
+}
+
+// parseSegment returns the capture node of seg, a segment of pattern, or
+// nil if seg is static. A segment mixing literals and captures is
+// compiled to one anchored regexp, a capture matching at least a byte and
+// as many as it can:
+//
+//	":name.:ext" -> ^([^/]+)\.([^/]+)$, "a.tar.gz" -> name "a.tar", ext "gz"
+func parseSegment(pattern, seg string) *node {
+	if strings.HasPrefix(seg, "*") {
+		return newWild(pattern, seg)
+	}
+	parts := splitSegment(pattern, seg)
+	if len(parts) <= 1 {
+		if parts == nil {
+			return nil
+		}
+		return parts[0]
+	}
+
+	n := &node{path: seg, kind: mixed, parts: parts}
+	group := 1
+	for _, part := range parts {
+		if part.kind == static {
+			continue
+		}
+		n.groups = append(n.groups, group)
+		if part.re != nil {
+			group += part.re.NumSubexp()
+		}
+		group++
+	}
+	n.constraint = mixedExpr(parts, regexp.QuoteMeta)
+	n.re = regexp.MustCompile("^" + n.constraint + "$")
+	return n
+}
+
+// mixedExpr returns the regexp of a mixed segment made of parts, literal
+// giving that of their literal text.
+func mixedExpr(parts []*node, literal func(string) string) string {
+	expr := ""
+	for _, part := range parts {
+		switch {
+		case part.kind == static:
+			expr += literal(part.path)
+		case part.re == nil:
+			expr += `([^/]+)`
+		default:
+			sub := part.re.String()
+			expr += "(" + sub[1:len(sub)-1] + ")"
+		}
+	}
+	return expr
+}
+
+// insert adds pattern, normalized by Handle, below n and returns its node.
+func (n *node) insert(pattern string) *node {
+	lit := 0 // start of the static text not inserted yet
+	for i := 0; i < len(pattern); {
+		// normalized patterns end with '/', so there is one.
+		end := i + strings.IndexByte(pattern[i:], '/')
+		if child := parseSegment(pattern, pattern[i:end]); child != nil {
+			if lit < i {
+				n = n.insertStatic(pattern[lit:i])
+			}
+			child.pattern = pattern
+			n = n.insertWild(pattern, child)
+			lit = end
+
+			if n.kind == catchAll {
+				if end+1 != len(pattern) {
+					panic(&Conflict{Problem: "catch-all must be the last segment", Pattern: pattern})
+				}
+				return n
+			}
+		}
+		i = end + 1
+	}
+	if lit < len(pattern) {
+		n = n.insertStatic(pattern[lit:])
+	}
+	return n
+}
+
+func (n *node) insertStatic(s string) *node {
+	for s != "" {
+		i := strings.IndexByte(n.indices, s[0])
+		if i == -1 {
+			child := &node{path: s}
+			// keep indices sorted, for a stable Print.
+			i = sort.Search(len(n.indices), func(j int) bool { return n.indices[j] > s[0] })
+			n.indices = n.indices[:i] + s[:1] + n.indices[i:]
+			n.statics = append(n.statics, nil)
+			copy(n.statics[i+1:], n.statics[i:])
+			n.statics[i] = child
+			return child
+		}
+
+		child := n.statics[i]
+		l := 0
+		for l < len(s) && l < len(child.path) && s[l] == child.path[l] {
+			l++
+		}
+		if l < len(child.path) {
+			// split child at l.
+			upper := &node{
+				path:    child.path[:l],
+				indices: child.path[l : l+1],
+				statics: []*node{child},
+			}
+			child.path = child.path[l:]
+			n.statics[i] = upper
+			child = upper
+		}
+		s = s[l:]
+		n = child
+	}
+	return n
+}
+
+func (n *node) insertWild(pattern string, child *node) *node {
+	for _, sibling := range n.wilds {
+		if sibling.path == child.path {
+			return sibling
+		} else if sibling.kind == child.kind && sibling.constraint == child.constraint {
+			panic(&Conflict{Problem: "pattern ambiguous", Pattern: pattern, Other: sibling.pattern})
+		}
+	}
+
+	// keep wilds ordered as they are tried in, see node.less.
+	i := len(n.wilds)
+	for i > 0 && child.less(n.wilds[i-1]) {
+		i--
+	}
+	n.wilds = append(n.wilds, nil)
+	copy(n.wilds[i+1:], n.wilds[i:])
+	n.wilds[i] = child
+
+	return child
+}
+
+// setHandlers wraps the handlers of rts in mws, then in their own
+// middleware, and those with a CORS policy, cors if not their own, in it.
+// Unless routes of rts are for them, HEAD is served by the GET handlers
+// and OPTIONS by the mux. The 405 and OPTIONS handlers are wrapped in mws.
+func (n *node) setHandlers(rts map[string]*route, mws []Middleware, cors *CORS) {
+	n.hs = make(map[string]http.Handler, len(rts)+2)
+
+	policies := make(map[string]*CORS, len(rts))
+	for method, rt := range rts {
+		// variants are chained from the last registered.
+		var alts []*route
+		for ; rt != nil; rt = rt.alt {
+			alts = append([]*route{rt}, alts...)
+		}
+		for _, rt := range alts {
+			c := rt.cors
+			if c == nil {
+				c = cors
+			}
+			if _, ok := policies[method]; !ok || len(rt.ms) == 0 {
+				policies[method] = c
+			}
+
+			h := chain(mws, chain(rt.mws, rt.h))
+			if c != nil {
+				h = c.handler(h)
+			}
+			if _, ok := rt.h.(contextRoute); ok {
+				h = contextChain{h}
+			}
+			if rt.md != nil {
+				h = metaRoute{h, rt.md}
+			}
+
+			if len(rt.ms) != 0 {
+				if n.variants == nil {
+					n.variants = make(map[string][]variant)
+				}
+				n.variants[method] = append(n.variants[method], variant{rt.ms, h, rt.defaults})
+				continue
+			}
+			n.hs[method] = h
+			if rt.defaults != nil {
+				if n.defaults == nil {
+					n.defaults = make(map[string]Params)
+				}
+				n.defaults[method] = rt.defaults
+			}
+		}
+	}
+
+	if _, ok := rts["HEAD"]; !ok {
+		if h, ok := n.hs["GET"]; ok {
+			n.hs["HEAD"] = headOf(h)
+			if n.defaults["GET"] != nil {
+				n.defaults["HEAD"] = n.defaults["GET"]
+			}
+		}
+		for _, v := range n.variants["GET"] {
+			v.h = headOf(v.h)
+			n.variants["HEAD"] = append(n.variants["HEAD"], v)
+		}
+	}
+	methods := allowed(rts)
+	if _, ok := rts["OPTIONS"]; !ok {
+		n.hs["OPTIONS"] = chain(mws, options{methods, policies})
+	}
+	n.notAllowed = chain(mws, methodNotAllowed(strings.Join(methods, ", ")))
+	n.notAcceptable = chain(mws, http.HandlerFunc(notAcceptable))
+}
+
+// less orders capture siblings the way they are tried: by kind, then
+// constrained and mixed ones by regexp, then by segment. This does not depend
+// on the registration order.
+func (n *node) less(o *node) bool {
+	if n.kind != o.kind {
+		return n.kind < o.kind
+	}
+	if n.constraint != o.constraint {
+		return n.constraint < o.constraint
+	}
+	return n.path < o.path
+}
+
+// lookup matches path, the rest after n, against the subtree of n.
+// The static child is tried first, then the capture ones, and a dead end
+// below one child backtracks to the next one, so a literal wins over a
+// capture only if the rest of the path matches too. The captures of a
+// dead end are dropped from ps.
+//
+// raw is path as requested when path is folded, "" otherwise, from the
+// start of the segment path is in: constraints, and mixed segments, are
+// matched against it, folding being for the literal text only.
+func (n *node) lookup(path, raw string, ps *Params) *node {
+	if path == "" {
+		if n.hs != nil {
+			return n
+		}
+	} else if i := strings.IndexByte(n.indices, path[0]); i != -1 {
+		child := n.statics[i]
+		if strings.HasPrefix(path, child.path) {
+			if found := child.lookup(path[len(child.path):], skipSegments(raw, child.path), ps); found != nil {
+				return found
+			}
+		}
+	}
+
+	for _, child := range n.wilds {
+		switch child.kind {
+		case mixed:
+			slashIdx := strings.IndexByte(path, '/')
+			if slashIdx == -1 {
+				continue
+			}
+			seg, re := path[:slashIdx], child.re
+			if raw != "" {
+				seg, re = raw[:strings.IndexByte(raw, '/')], child.rawRe
+			}
+			loc := re.FindStringSubmatchIndex(seg)
+			if loc == nil {
+				continue
+			}
+			l := len(*ps)
+			for _, part := range child.parts {
+				if part.kind != static {
+					g := child.groups[len(*ps)-l]
+					*ps = append(*ps, Capture{part.key, seg[loc[2*g]:loc[2*g+1]]})
+				}
+			}
+			if found := child.lookup(path[slashIdx:], raw, ps); found != nil {
+				return found
+			}
+			*ps = (*ps)[:l]
+		case constraint, param:
+			slashIdx := strings.IndexByte(path, '/')
+			if slashIdx == -1 {
+				continue
+			}
+			seg := path[:slashIdx]
+			if child.re != nil {
+				value := seg
+				if raw != "" {
+					value = raw[:strings.IndexByte(raw, '/')]
+				}
+				if !child.re.MatchString(unescapeValue(value)) {
+					continue
+				}
+			}
+			*ps = append(*ps, Capture{child.key, seg})
+			if found := child.lookup(path[slashIdx:], raw, ps); found != nil {
+				return found
+			}
+			*ps = (*ps)[:len(*ps)-1]
+		case catchAll:
+			// the whole rest, slashes included, possibly empty.
+			if child.hs != nil {
+				*ps = append(*ps, Capture{child.key, path})
+				return child
+			}
+		}
+	}
+
+	// println("[debug]not match: " + n.path + " -> " + path)
+	return nil
+}
+
+// skipSegments returns raw after as many segments as matched has
+// slashes, see lookup.
+func skipSegments(raw, matched string) string {
+	if raw == "" {
+		return ""
+	}
+	for i := strings.IndexByte(matched, '/'); i != -1; i = strings.IndexByte(matched, '/') {
+		matched = matched[i+1:]
+		raw = raw[strings.IndexByte(raw, '/')+1:]
+	}
+	return raw
+}
+
+func printNode(n *node, indent string) {
+	name := n.path
+	if n.hs != nil {
+		name += "(h)"
+	}
+	println(indent + name)
+	for _, child := range n.statics {
+		printNode(child, indent+"\t")
+	}
+	for _, child := range n.wilds {
+		printNode(child, indent+"\t")
+	}
+}
@@ -0,0 +1,159 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrRouteNotFound = errors.New("mux: route not found")
+
+// urlTemplate is a named pattern, parsed to build URLs from.
+type urlTemplate struct {
+	pattern  string  // the longest form, see expand
+	segs     []*node // static segments have no kind but path
+	optional int     // the number of trailing optional segments
+}
+
+func newURLTemplate(pattern string) *urlTemplate {
+	patterns, _ := expand(pattern)
+	t := &urlTemplate{pattern: patterns[0], optional: len(patterns) - 1}
+	for _, seg := range strings.Split(strings.TrimSuffix(t.pattern, "/"), "/") {
+		if w := parseSegment(pattern, seg); w != nil {
+			t.segs = append(t.segs, w)
+		} else if seg != "" {
+			t.segs = append(t.segs, &node{path: seg})
+		}
+	}
+	return t
+}
+
+// build applies params to the template. Every capture must be given and
+// satisfy its constraint, but optional ones, the path ending at the first
+// one missing; values are percent-encoded, a catch-all one keeping its
+// slashes.
+func (t *urlTemplate) build(prefix string, params map[string]string) (string, error) {
+	buf := []byte(prefix)
+	for i, seg := range t.segs {
+		if _, ok := params[seg.key]; !ok && i >= len(t.segs)-t.optional {
+			break
+		}
+
+		if seg.kind == static {
+			buf = append(buf, seg.path...)
+			buf = append(buf, '/')
+			continue
+		}
+
+		if seg.kind == mixed {
+			for _, part := range seg.parts {
+				if part.kind == static {
+					buf = append(buf, part.path...)
+					continue
+				}
+				v, err := t.capture(part, params)
+				if err != nil {
+					return "", err
+				}
+				buf = append(buf, url.PathEscape(v)...)
+			}
+			buf = append(buf, '/')
+			continue
+		}
+
+		v, err := t.capture(seg, params)
+		if err != nil {
+			return "", err
+		}
+		switch seg.kind {
+		case catchAll:
+			parts := strings.Split(v, "/")
+			for i, part := range parts {
+				parts[i] = url.PathEscape(part)
+			}
+			buf = append(buf, strings.Join(parts, "/")...)
+		default:
+			buf = append(buf, url.PathEscape(v)...)
+			buf = append(buf, '/')
+		}
+	}
+	return string(buf), nil
+}
+
+// capture returns the value of the capture w, checked against its constraint.
+func (t *urlTemplate) capture(w *node, params map[string]string) (string, error) {
+	v, ok := params[w.key]
+	if !ok {
+		return "", fmt.Errorf("mux: %s: missing capture %q", t.pattern, w.key)
+	}
+	if w.re != nil && !w.re.MatchString(v) {
+		return "", fmt.Errorf("mux: %s: capture %q does not satisfy {%s}: %q", t.pattern, w.key, w.constraint, v)
+	}
+	return v, nil
+}
+
+// HandleNamed is Handle, naming the route for URL.
+func (mux *Mux) HandleNamed(name, pattern string, h http.Handler) {
+	mux.handleNamed(name, pattern, &route{h: h})
+}
+
+func (mux *Mux) handleNamed(name, pattern string, rt *route) {
+	pattern = cleanPattern(pattern)
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	var t *urlTemplate
+	if c := catch(func() { t = newURLTemplate(pattern) }); c != nil {
+		mux.fail(c)
+		return
+	}
+	if _, ok := mux.names[name]; ok {
+		mux.fail(&Conflict{Problem: "name existed", Pattern: pattern, Other: name})
+		return
+	}
+	if mux.add("", pattern, rt) {
+		mux.names[name] = t
+	}
+}
+
+// URL builds the path of the route named name, prefix included, from the
+// captures in params. For a mounted mux, the prefix is the path of the
+// pattern it is mounted at, whose captures params has too.
+//
+//	m.URL("feed", map[string]string{"user-id": "u1", "feed-id": "f1"})
+//	// "/api/users/u1/feeds/f1/", nil
+func (mux *Mux) URL(name string, params map[string]string) (string, error) {
+	mux.mu.Lock()
+	t, ok := mux.names[name]
+	mux.mu.Unlock()
+
+	if !ok {
+		return "", ErrRouteNotFound
+	}
+	prefix, err := mux.base(params)
+	if err != nil {
+		return "", err
+	}
+	return t.build(prefix, params)
+}
+
+// base returns the path the patterns of mux are below: its prefix, that
+// of its parent for a host Mux, or the mount pattern below the parent.
+func (mux *Mux) base(params map[string]string) (string, error) {
+	if mux.parent == nil {
+		return mux.prefix, nil
+	}
+	prefix, err := mux.parent.base(params)
+	if err != nil || mux.mountAt == "" {
+		return prefix, err
+	}
+
+	var t *urlTemplate
+	if c := catch(func() { t = newURLTemplate(mux.mountAt) }); c != nil {
+		return "", c
+	}
+	return t.build(prefix, params)
+}